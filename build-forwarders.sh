@@ -8,3 +8,5 @@ make_go_binary etcdconfs.go
 #make_go_binary neardconfs.go
 make_go_binary restconfs.go
 make_go_binary tarconfs.go
+make_go_binary resetconfs.go
+make_go_binary i18nconfs.go
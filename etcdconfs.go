@@ -3,40 +3,126 @@ package main
 import (
 	"flag"
 	"log"
+	"os"
 	"strings"
+	"time"
 	"ostro/etcd/confs"
+	"ostro/lifecycle"
 )
 
 func main() {
 	var servers, prefix, cache string
+	var fleetRoot, deviceId, deviceIdFile, groups, groupsFile string
+	var statusPrefix string
+	var statusTTL time.Duration
+	var pushSubtree, pushOrigins string
+	var cfs *confs.ConFS
+
+	creds := &confs.Credentials{}
 
         flag.StringVar(&servers, "servers", "http://localhost:2379", "comma separated list of URLS")
 	flag.StringVar(&prefix, "prefix", "/", "etcd key prefix")
 	flag.StringVar(&cache, "cache", "/var/cache/confs", "etcd key hierarchy will go under this directory")
+	flag.StringVar(&fleetRoot, "fleet-root", "", "etcd root of the fleet layout (<root>/defaults, <root>/groups/<group>, <root>/devices/<device-id>); overrides -prefix")
+	flag.StringVar(&deviceId, "device-id", "", "device identity; takes precedence over -device-id-file")
+	flag.StringVar(&deviceIdFile, "device-id-file", "/etc/machine-id", "file containing the device identity")
+	flag.StringVar(&groups, "groups", "", "comma separated list of groups in increasing order of precedence; takes precedence over -groups-file")
+	flag.StringVar(&groupsFile, "groups-file", "", "file listing the groups of the device, one per line, in increasing order of precedence")
+	flag.StringVar(&statusPrefix, "status-prefix", "/status", "etcd prefix where the applied configuration status of the device is published; empty disables reporting")
+	flag.DurationVar(&statusTTL, "status-ttl", 60 * time.Second, "TTL of the published status key")
+	flag.StringVar(&pushSubtree, "push-subtree", "", "drop zone subtree, like /local/device, whose local changes are pushed to the device namespace; empty disables")
+	flag.StringVar(&pushOrigins, "push-origins", "Neard,Etcd,Rest", "origins in decreasing priority resolving conflicts of two-way synchronisation")
+	flag.StringVar(&creds.CAFile, "ca-file", "", "CA bundle to verify the etcd servers with")
+	flag.StringVar(&creds.CertFile, "cert-file", "", "TLS client certificate; reloaded when it changes")
+	flag.StringVar(&creds.KeyFile, "key-file", "", "TLS client key; reloaded when it changes")
+	flag.StringVar(&creds.Username, "username", "", "etcd user name")
+	flag.StringVar(&creds.PasswordFile, "password-file", "", "file containing the password of the etcd user; ETCDCONFS_PASSWORD is used otherwise")
+	flag.BoolVar(&creds.SyncEndpoints, "sync-endpoints", true, "replace -servers with the client URLs advertised by the cluster on startup")
+	flag.DurationVar(&creds.AutoSyncInterval, "auto-sync-interval", 0, "interval of re-discovering the cluster endpoints; 0 disables")
 
+	lifecycle.AddFlags()
 	flag.Parse()
 
+	lifecycle.Start()
+
+	if creds.PasswordFile == "" {
+		creds.Password = os.Getenv("ETCDCONFS_PASSWORD")
+	}
+
 	serverList := strings.Split(strings.Replace(servers, " ", "", -1), ",")
 	
-	cfs := confs.NewConFS(serverList, prefix, cache)
+	id, groupList, idErr := confs.DeviceIdentity(deviceId, deviceIdFile, groups, groupsFile)
+
+	if fleetRoot == "" {
+		cfs = confs.NewConFS(serverList, creds, prefix, cache)
+	} else {
+		if idErr != nil {
+			lifecycle.Fatalf("failed to identify device: %v\n", idErr)
+		}
+		log.Printf("device '%s' member of groups %v\n", id, groupList)
+
+		cfs = confs.NewNamespacedConFS(serverList, creds, confs.FleetNamespaces(fleetRoot, id, groupList), cache)
+	}
+
+	if statusPrefix != "" {
+		if idErr != nil {
+			log.Printf("status reporting disabled: %v\n", idErr)
+		} else if err := cfs.EnableStatusReport(statusPrefix, id, statusTTL); err != nil {
+			lifecycle.Fatalf("failed to enable status reporting: %v\n", err)
+		}
+	}
+
+	if pushSubtree != "" {
+		opts := confs.PushOptions{
+			Subtree: pushSubtree,
+			Origins: strings.Split(strings.Replace(pushOrigins, " ", "", -1), ",")}
+		if err := cfs.EnablePush(opts); err != nil {
+			lifecycle.Fatalf("failed to enable two-way synchronisation: %v\n", err)
+		}
+	}
 
 	changes, err := cfs.TraverseEtcdTree()
 	if err != nil {
-		log.Fatal("failed to initialize.\n")
+		lifecycle.Fatalf("failed to initialize.\n")
 	}
 	
 	if err := cfs.SyncFiles(changes, false); err != nil {
-		log.Fatalf("failed to update cache: %v\n", err)
+		lifecycle.Fatalf("failed to update cache: %v\n", err)
 	}
 	
 	change_chan, err_chan := cfs.PollForChanges()
+	local_chan, local_err_chan := cfs.PollForLocalChanges()
+
+	// SIGHUP syncs the whole tree again, like on startup
+	reload_chan := make(chan struct{}, 1)
+	lifecycle.OnReload(func() {
+		select {
+		case reload_chan <- struct{}{}:
+		default:
+		}
+	})
 	
 	for {
 		select {
 		case changes := <-change_chan:
 			cfs.SyncFiles(changes, true)
+		case <-reload_chan:
+			if changes, err := cfs.TraverseEtcdTree(); err == nil {
+				cfs.SyncFiles(changes, false)
+			}
+		case events, ok := <-local_chan:
+			if !ok {
+				local_chan = nil
+				continue
+			}
+			cfs.PushFiles(events)
 		case err := <-err_chan:
-			log.Fatalf("polling failed: %v\n", err)
+			lifecycle.Fatalf("polling failed: %v\n", err)
+		case err := <-local_err_chan:
+			lifecycle.Fatalf("watching drop zone failed: %v\n", err)
+		case <-lifecycle.Done():
+			lifecycle.Wait()
+			return
 		}
 	}
 }
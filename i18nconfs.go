@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"flag"
+	"strings"
+	"io/ioutil"
+	"path/filepath"
+	"encoding/json"
+	"ostro/confs"
+	"ostro/confui"
+	"ostro/lifecycle"
+)
+
+// i18nconfs lists the texts of the UI and the definitions that a catalog
+// does not translate, as JSON to be filled in, and exits with 1 if there
+// are any.
+func main() {
+	var (
+		langs string
+		all, write bool
+	)
+
+	flag.StringVar(&langs, "lang", "", "comma separated languages to check, like de,fr; all the catalogs if empty")
+	flag.BoolVar(&all, "all", false, "list every text, eg. to start the catalog of a new language")
+	flag.BoolVar(&write, "write", false, "add the untranslated texts to the catalogs with empty translations")
+
+	lifecycle.AddFlags()
+	flag.Parse()
+
+	lifecycle.Start()
+
+	if err := confs.Initialize("Cli"); err != nil {
+		lifecycle.Fatalf("failed to initialize: %v", err)
+	}
+
+	root := confs.DefinitionRoot()
+
+	texts, err := confui.Texts(root)
+	if err != nil {
+		lifecycle.Fatalf("failed to read the definitions: %v", err)
+	}
+
+	var languages []string
+	if langs != "" {
+		languages = strings.Split(langs, ",")
+	} else {
+		languages = confui.Languages(root)
+	}
+
+	untranslated := map[string]confui.Catalog{}
+	missing := 0
+
+	for _, lang := range languages {
+		lang = strings.TrimSpace(lang)
+		path := filepath.Join(root, confui.LocaleDir, lang + ".json")
+
+		catalog, err := confui.ReadCatalog(path)
+		if os.IsNotExist(err) {
+			catalog = confui.Catalog{}
+		} else if err != nil {
+			lifecycle.Fatalf("%v", err)
+		}
+
+		list := confui.Catalog{}
+		for _, text := range texts {
+			if all || catalog[text] == "" {
+				list[text] = catalog[text]
+			}
+			if catalog[text] == "" {
+				missing++
+				catalog[text] = ""
+			}
+		}
+		untranslated[lang] = list
+
+		if write {
+			content, _ := json.MarshalIndent(catalog, "", "    ")
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				lifecycle.Fatalf("%v", err)
+			}
+			if err := ioutil.WriteFile(path, append(content, '\n'), 0644); err != nil {
+				lifecycle.Fatalf("failed to write '%s': %v", path, err)
+			}
+		}
+	}
+
+	content, _ := json.MarshalIndent(untranslated, "", "    ")
+	fmt.Println(string(content))
+
+	if missing > 0 {
+		lifecycle.Exit(1)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"ostro/neard"
 	"ostro/confs"
+	"ostro/lifecycle"
 )
 
 
@@ -26,8 +27,11 @@ var (
 )
 
 func main() {
+	lifecycle.AddFlags()
 	flag.Parse()
 
+	lifecycle.Start()
+
 	neard.Initialize()
 
 	if server := neard.NewServer([]string{"text", "wifi"}); server != nil {
@@ -42,6 +46,9 @@ func main() {
 				if name, ad := getAdapter(); ad != nil && !ad.active {
 					server.Activate(name)
 				}
+			case <- lifecycle.Done():
+				lifecycle.Wait()
+				return
 			}
 		}
 	}
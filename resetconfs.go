@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"flag"
+	"strings"
+	"ostro/confs"
+	"ostro/lifecycle"
+)
+
+const (
+	CliOriginated = "Cli"
+)
+
+func main() {
+	var (
+		scopes string
+		opts confs.ResetOptions
+	)
+
+	flag.BoolVar(&opts.Common, "common", false, "reset the common subtree as well as the local one")
+	flag.StringVar(&scopes, "scope", "", "comma separated nodes to reset, like /network; everything if empty")
+	flag.BoolVar(&opts.DryRun, "dry-run", false, "only list the files that would be removed")
+
+	lifecycle.AddFlags()
+	flag.Parse()
+
+	lifecycle.Start()
+
+	if err := confs.Initialize(CliOriginated); err != nil {
+		lifecycle.Fatalf("failed to initialize: %v", err)
+	}
+
+	opts.Origin = CliOriginated
+	if scopes != "" {
+		opts.Scopes = strings.Split(scopes, ",")
+	}
+
+	record, err := confs.FactoryReset(opts)
+	if record != nil {
+		for _, path := range record.Removed {
+			fmt.Println(path)
+		}
+	}
+	if err != nil {
+		lifecycle.Fatalf("factory reset failed: %s", err.String())
+	}
+}
@@ -1,44 +1,186 @@
 package main
 
 import (
-	"log"
+	"fmt"
 	"flag"
+	"path"
+	"net/http"
+	"log"
+	"os"
+	"time"
 	"strings"
 	"ostro/rest"
+	"ostro/cors"
+	"ostro/session"
+	"ostro/confs"
 	"ostro/confui"
+	"ostro/lifecycle"
+	"ostro/tlscert"
 )
 
 
 func main() {
 	var httpPrefixRaw, restPrefixRaw, dropZoneRootRaw, uiRootRaw, certFile, keyFile string
 	var httpPort, restPort int;
+	var restSocket, restSocketGroup string
+	var restSocketMode uint
+	var restMaxBody int64
+	var singleListener bool
+	var uiPasswordFile, uiLanguage string
+	var sessionTTL time.Duration
+	var sessions *session.Manager
+	var corsOrigins, corsExpose string
+	var corsCredentials bool
+	var corsMaxAge time.Duration
+	var selfSigned bool
+	var tlsDir, tlsHosts string
+	var certificate *tlscert.Provider
+	var authConfig rest.AuthConfig
+	var auth *rest.Auth
 
 	flag.IntVar(&restPort, "rest-port", 4984, "REST server port")
 	flag.StringVar(&restPrefixRaw, "rest-prefix", "/confs/local", "REST resource prefix")
+	flag.StringVar(&restSocket, "rest-socket", "", "Unix domain socket for local REST clients, who need no authentication")
+	flag.UintVar(&restSocketMode, "rest-socket-mode", 0660, "permissions of -rest-socket")
+	flag.StringVar(&restSocketGroup, "rest-socket-group", "", "group owning -rest-socket")
+	flag.Int64Var(&restMaxBody, "rest-max-body", rest.DefaultMaxBodySize, "size limit of the REST request bodies in bytes")
   flag.StringVar(&dropZoneRootRaw, "cache", "/var/cache/confs", "root of the Drop Zone")
 
 	flag.IntVar(&httpPort, "http-port", 8080, "HTTP server port")
 	flag.StringVar(&httpPrefixRaw, "http-prefix", "/confs", "UI URL prefix")
 	flag.StringVar(&uiRootRaw, "ui-files", "/usr/share/confs/ui", "root directory of the UI files")
+	flag.StringVar(&uiLanguage, "ui-language", "en", "language of the definitions, used when no catalog in <ui-files>/locale suits the browser")
+	flag.BoolVar(&singleListener, "single-listener", false, "serve the UI on the REST port and socket too, with -http-port unused; give the REST API a prefix of its own, like /api/confs/local")
+	flag.StringVar(&uiPasswordFile, "ui-password-file", "", "UI users, in the format of -rest-password-file; makes the UI ask for a login, whose session then authenticates the REST calls of the pages")
+	flag.DurationVar(&sessionTTL, "session-ttl", session.DefaultTTL, "idle time after which UI sessions end")
 	flag.StringVar(&certFile, "certificate-file", "", "TLS certificate")
 	flag.StringVar(&keyFile, "key-file", "", "private key file")
+	flag.BoolVar(&selfSigned, "self-signed-tls", true, "without -certificate-file and -key-file, serve TLS with a self-signed certificate of a key created for the device")
+	flag.StringVar(&tlsDir, "tls-dir", "/var/lib/confs/tls", "where the self-signed certificate and its key are kept")
+	flag.StringVar(&tlsHosts, "tls-hosts", "", "comma separated names and addresses for the self-signed certificate besides the hostname and the current IPs")
+	flag.StringVar(&authConfig.TokenFile, "rest-token-file", "", "REST bearer tokens, lines of '<token> <user> <role>[,<role>...]'")
+	flag.StringVar(&authConfig.PasswordFile, "rest-password-file", "", "REST users, lines of '<user>:<bcrypt hash>:<role>[,<role>...]'; hash '!' allows certificate logins only")
+	flag.StringVar(&authConfig.ClientCAFile, "rest-client-ca-file", "", "CA bundle of the accepted REST client certificates; needs TLS")
+	flag.StringVar(&authConfig.RulesFile, "rest-access-rules", "", "REST access rules, lines of '<path> <read roles> <write roles>', '-' for none; longest path wins, and recursive requests need the rules under the path too")
+
+	flag.StringVar(&corsOrigins, "cors-origins", "", "comma separated origins allowed to use the servers, like 'https://ui.example.com', 'https://*.example.com', 'same-host' or '*'; by default any without and the same host with REST authentication")
+	flag.BoolVar(&corsCredentials, "cors-credentials", false, "share credentials with the -cors-origins, which must not be '*'")
+	flag.DurationVar(&corsMaxAge, "cors-max-age", 10 * time.Minute, "how long browsers may cache preflight results")
+	flag.StringVar(&corsExpose, "cors-expose-headers", "", "comma separated response headers to expose in addition to ETag")
 
+	lifecycle.AddFlags()
 	flag.Parse()
 
+	lifecycle.Start()
+
 	if restPort < 1 || restPort > 65535 {
-		log.Fatalf("invalid rest-port %d: out of range 1 - 65535", restPort)
+		lifecycle.Fatalf("invalid rest-port %d: out of range 1 - 65535", restPort)
 	}
 	restPrefix := strings.TrimRight(restPrefixRaw, "/")
 	dropZoneRoot := strings.TrimRight(dropZoneRootRaw, "/")
 
+	// REST writes are validated by ostro/confs, which has to agree on the drop zone
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	if !explicit["drop-zone"] {
+		flag.Set("drop-zone", dropZoneRoot)
+	} else if !explicit["cache"] {
+		dropZoneRoot = strings.TrimRight(flag.Lookup("drop-zone").Value.String(), "/")
+	} else if dropZone := strings.TrimRight(flag.Lookup("drop-zone").Value.String(), "/"); dropZone != dropZoneRoot {
+		lifecycle.Fatalf("-cache '%s' and -drop-zone '%s' differ; set one of them", dropZoneRoot, dropZone)
+	}
+	if !explicit["definition-root"] {
+		flag.Set("definition-root", strings.TrimRight(uiRootRaw, "/"))
+	}
+	if err := confs.Initialize(rest.RestOriginated); err != nil {
+		lifecycle.Fatalf("failed to initialize: %v", err)
+	}
+
 	if httpPort < 1 || httpPort > 65535 {
-		log.Fatalf("invalid http-port %d: out of range 1 - 65535", httpPort)
+		lifecycle.Fatalf("invalid http-port %d: out of range 1 - 65535", httpPort)
 	}
 	httpPrefix := strings.TrimRight(httpPrefixRaw, "/")
 	uiRoot := strings.TrimRight(uiRootRaw, "/")
+	if err := confui.SetDefaultLanguage(uiLanguage); err != nil {
+		lifecycle.Fatalf("%v", err)
+	}
+
+	if selfSigned && (certFile == "" || keyFile == "") {
+		var err error
+		certificate, err = tlscert.NewProvider(tlscert.Config{Dir: tlsDir, Hosts: cors.SplitList(tlsHosts)})
+		if err != nil {
+			lifecycle.Fatalf("failed to set up the TLS certificate: %v", err)
+		}
+		log.Printf("TLS with the self-signed certificate in '%s'\n", tlsDir)
+		confui.SetCertificate(certificate)
+	}
+
+	if uiPasswordFile != "" {
+		var err error
+		sessions, err = session.NewManager(session.Config{
+			PasswordFile: uiPasswordFile,
+			TTL: sessionTTL,
+			Secure: (certFile != "" && keyFile != "") || certificate != nil})
+		if err != nil {
+			lifecycle.Fatalf("failed to set up UI sessions: %v", err)
+		}
+	}
+
+	if authConfig.TokenFile != "" || authConfig.PasswordFile != "" || authConfig.ClientCAFile != "" || sessions != nil {
+		var err error
+		if auth, err = rest.NewAuth(authConfig); err != nil {
+			lifecycle.Fatalf("failed to set up REST authentication: %v", err)
+		}
+		if sessions != nil {
+			auth.AddAuthenticator(rest.SessionAuthenticator(sessions))
+		}
+	} else {
+		log.Printf("REST authentication is disabled\n")
+	}
+
+	var restPolicy, uiPolicy *cors.Policy
+	if corsOrigins != "" {
+		restPolicy = rest.CORSPolicy(cors.SplitList(corsOrigins), corsCredentials, corsMaxAge, cors.SplitList(corsExpose))
+		uiPolicy = &cors.Policy{
+			Origins: restPolicy.Origins,
+			Methods: []string{"GET", "HEAD"},
+			Credentials: corsCredentials,
+			MaxAge: corsMaxAge}
+	} else {
+		restPolicy = rest.DefaultPolicy(auth)
+		restPolicy.MaxAge = corsMaxAge
+	}
+
+	// the pages address the resources relative to the REST base, like /local/network
+	restBase := path.Dir(restPrefix)
 
-	rest.NewFileHandler("", restPort, restPrefix + "/", dropZoneRoot + "/local", dropZoneRoot + "/tmp", certFile, keyFile)
-	confui.NewServer("", httpPort, httpPrefix + "/", uiRoot, certFile, keyFile)
+	var ui http.Handler
+	if singleListener {
+		var err error
+		if ui, err = confui.NewHandler(httpPrefix + "/", uiRoot, uiPolicy, restBase, sessions); err != nil {
+			lifecycle.Fatalf("failed to set up UI handler: %v", err)
+		}
+	}
+
+	handler, err := rest.NewHandler(rest.Config{Root: dropZoneRoot, Prefix: restPrefix, MaxBodySize: restMaxBody, Auth: auth, CORS: restPolicy, Events: true, NetworkStatus: rest.SystemNetworkStatus, Certificate: certificate, Fallback: ui})
+	if err != nil {
+		lifecycle.Fatalf("failed to set up REST handler: %v", err)
+	}
+	// the pages are rendered on the server as well, for browsers without scripts
+	confui.SetStore(handler)
+	if err := rest.NewServer("", restPort, handler, certFile, keyFile); err != nil {
+		lifecycle.Fatalf("failed to start REST server: %v", err)
+	}
+	if err := rest.NewUnixServer(restSocket, os.FileMode(restSocketMode), restSocketGroup, handler); err != nil {
+		lifecycle.Fatalf("failed to start local REST server: %v", err)
+	}
+	if !singleListener {
+		if err := confui.NewServer("", httpPort, httpPrefix + "/", uiRoot, uiPolicy, fmt.Sprintf(":%d%s", restPort, restBase), sessions, certFile, keyFile); err != nil {
+			lifecycle.Fatalf("failed to start UI server: %v", err)
+		}
+	}
 
-	select {}
+	lifecycle.Wait()
 }
@@ -0,0 +1,141 @@
+package activation
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"strconv"
+	"strings"
+	"syscall"
+	"os/user"
+)
+
+const (
+	firstFd = 3
+
+	// systemd names the fds like this when FileDescriptorName= is not set
+	Unnamed = "unknown"
+)
+
+type socket struct {
+	name string
+	file *os.File
+}
+
+var (
+	once sync.Once
+	mutex sync.Mutex
+	sockets []socket
+)
+
+// load picks up the sockets passed by systemd, once for the process, and
+// hides them from the children.
+func load() {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return
+	}
+
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds < 1 {
+		return
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	for i := 0;  i < nfds;  i++ {
+		fd := firstFd + i
+		name := Unnamed
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		syscall.CloseOnExec(fd)
+		sockets = append(sockets, socket{name: name, file: os.NewFile(uintptr(fd), name)})
+	}
+
+	log.Printf("%d socket(s) passed by systemd\n", nfds)
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+}
+
+// Take returns the listener of the passed socket with the given name.
+// With fallback, an unnamed socket is taken when there is no such name,
+// as with units passing a single socket. It returns nil when there is no
+// matching socket, and every socket can be taken once.
+func Take(name string, fallback bool) (net.Listener, error) {
+	once.Do(load)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	idx := -1
+	for i, s := range sockets {
+		if s.name == name {
+			idx = i
+			break
+		}
+		if fallback && idx < 0 && s.name == Unnamed {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return nil, nil
+	}
+
+	s := sockets[idx]
+	sockets = append(sockets[:idx], sockets[idx+1:]...)
+
+	ln, err := net.FileListener(s.file)
+	s.file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("socket '%s' is not a listening socket: %v", s.name, err)
+	}
+
+	return ln, nil
+}
+
+// ListenUnix listens on a Unix domain socket whose permissions decide who
+// may connect. A socket left over by a previous run is replaced; any
+// other file is not touched.
+func ListenUnix(path string, mode os.FileMode, group string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode() & os.ModeSocket == 0 {
+			return nil, fmt.Errorf("'%s' exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	// connecting needs write permission, which the default umask leaves
+	// to the owner only until the mode is set
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if group != "" {
+		grp, err := user.LookupGroup(group)
+		if err == nil {
+			var gid int
+			if gid, err = strconv.Atoi(grp.Gid); err == nil {
+				err = os.Chown(path, -1, gid)
+			}
+		}
+		if err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("failed to give '%s' to group '%s': %v", path, group, err)
+		}
+	}
+
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	return ln, nil
+}
@@ -0,0 +1,441 @@
+package confs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"strconv"
+	"io/ioutil"
+)
+
+// Definition is the pageDef object of a definition file, like
+// /usr/share/confs/ui/network/wifi.js. Functions and expressions other
+// than literals, eg. event handlers or pattern helpers, read as nil.
+type Definition map[string]interface{}
+
+// keyOrder is the entry of the parsed objects listing their keys in the
+// order of the source, which is the order the pages show the fields in.
+const keyOrder = "\x00keys"
+
+// Keys returns the keys of an object of a definition in source order.
+// Objects of other origins get their keys sorted.
+func Keys(object map[string]interface{}) []string {
+	if keys, ok := object[keyOrder].([]string); ok {
+		return keys
+	}
+
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		if key != keyOrder {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// DefinitionRoot is the directory of the definition files.
+func DefinitionRoot() string {
+	return defRoot
+}
+
+func ReadDefinition(path string) (Definition, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseDefinition(string(content))
+}
+
+// ParseDefinition reads the object assigned to pageDef. The parser is
+// tolerant: it accepts what browsers do for object literals, ie. unquoted
+// keys, single quotes, comments and trailing commas.
+func ParseDefinition(src string) (Definition, error) {
+	p := &jsParser{src: src}
+
+	// the assignment, not a mention in a comment or a string
+	for assigned := false;  !assigned; {
+		p.skipSpace()
+		switch c := p.peek(); {
+		case c == 0:
+			return nil, fmt.Errorf("no pageDef found")
+		case c == '"' || c == '\'' || c == '`':
+			if _, err := p.str(); err != nil {
+				return nil, err
+			}
+		case c == '_' || c == '$' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+			if p.word() == "pageDef" {
+				p.skipSpace()
+				if p.peek() == '=' && !strings.HasPrefix(p.src[p.pos:], "==") {
+					p.pos++
+					assigned = true
+				}
+			}
+		default:
+			p.pos++
+		}
+	}
+
+	p.skipSpace()
+	if p.peek() != '{' {
+		return nil, p.errorf("pageDef is not an object")
+	}
+
+	value, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+
+	// like an object with a method called on it
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, p.errorf("pageDef is not an object")
+	}
+
+	return Definition(object), nil
+}
+
+type jsParser struct {
+	src string
+	pos int
+}
+
+func (me *jsParser) errorf(format string, args ...interface{}) error {
+	line := strings.Count(me.src[:me.pos], "\n") + 1
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func (me *jsParser) peek() byte {
+	if me.pos >= len(me.src) {
+		return 0
+	}
+	return me.src[me.pos]
+}
+
+func (me *jsParser) skipSpace() {
+	for me.pos < len(me.src) {
+		switch {
+		case strings.ContainsRune(" \t\r\n\f", rune(me.src[me.pos])):
+			me.pos++
+		case strings.HasPrefix(me.src[me.pos:], "//"):
+			if end := strings.Index(me.src[me.pos:], "\n"); end >= 0 {
+				me.pos += end + 1
+			} else {
+				me.pos = len(me.src)
+			}
+		case strings.HasPrefix(me.src[me.pos:], "/*"):
+			if end := strings.Index(me.src[me.pos+2:], "*/"); end >= 0 {
+				me.pos += end + 4
+			} else {
+				me.pos = len(me.src)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// value parses a literal, or skips an expression it does not understand.
+func (me *jsParser) value() (interface{}, error) {
+	me.skipSpace()
+
+	var v interface{}
+	var err error
+
+	switch c := me.peek(); {
+	case c == 0:
+		return nil, me.errorf("unexpected end")
+	case c == '{':
+		v, err = me.object()
+	case c == '[':
+		v, err = me.array()
+	case c == '"' || c == '\'':
+		v, err = me.str()
+	case c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		v, err = me.number()
+	default:
+		word := me.word()
+		switch word {
+		case "true":
+			v = true
+		case "false":
+			v = false
+		case "null", "undefined":
+			v = nil
+		case "":
+			if c == '/' {
+				if err := me.skipRegexp(); err != nil {
+					return nil, err
+				}
+				break
+			}
+			if err := me.skipExpression(); err != nil {
+				return nil, err
+			}
+			return nil, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// anything but a literal, like a function, a call or a concatenation
+	me.skipSpace()
+	if c := me.peek(); c != ',' && c != '}' && c != ']' && c != ';' && c != 0 {
+		if err := me.skipExpression(); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	return v, nil
+}
+
+func (me *jsParser) word() string {
+	start := me.pos
+	for me.pos < len(me.src) {
+		c := me.src[me.pos]
+		if c != '_' && c != '$' && (c < '0' || c > '9') && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') {
+			break
+		}
+		me.pos++
+	}
+	return me.src[start:me.pos]
+}
+
+// skipRegexp moves past a regular expression literal and its flags.
+func (me *jsParser) skipRegexp() error {
+	inClass := false
+
+	for me.pos++;  me.pos < len(me.src);  me.pos++ {
+		switch me.src[me.pos] {
+		case '\\':
+			me.pos++
+		case '[':
+			inClass = true
+		case ']':
+			inClass = false
+		case '/':
+			if !inClass {
+				me.pos++
+				me.word()
+				return nil
+			}
+		case '\n':
+			return me.errorf("unterminated regular expression")
+		}
+	}
+
+	return me.errorf("unterminated regular expression")
+}
+
+// skipExpression moves to the ',', '}' or ']' ending the expression.
+func (me *jsParser) skipExpression() error {
+	depth := 0
+
+	for me.pos < len(me.src) {
+		me.skipSpace()
+		switch c := me.peek(); c {
+		case '"', '\'', '`':
+			if _, err := me.str(); err != nil {
+				return err
+			}
+			continue
+		case '{', '[', '(':
+			depth++
+		case '}', ']', ')':
+			if depth == 0 {
+				return nil
+			}
+			depth--
+		case ',', ';':
+			if depth == 0 {
+				return nil
+			}
+		}
+		me.pos++
+	}
+
+	return me.errorf("unexpected end")
+}
+
+func (me *jsParser) object() (interface{}, error) {
+	object := map[string]interface{}{}
+	keys := []string{}
+	me.pos++
+
+	for {
+		me.skipSpace()
+		if me.peek() == '}' {
+			me.pos++
+			object[keyOrder] = keys
+			return object, nil
+		}
+
+		var key string
+		switch c := me.peek(); {
+		case c == '"' || c == '\'':
+			s, err := me.str()
+			if err != nil {
+				return nil, err
+			}
+			key = s.(string)
+		default:
+			if key = me.word(); key == "" {
+				return nil, me.errorf("invalid key")
+			}
+		}
+
+		me.skipSpace()
+		if me.peek() != ':' {
+			return nil, me.errorf("missing ':' after '%s'", key)
+		}
+		me.pos++
+
+		value, err := me.value()
+		if err != nil {
+			return nil, err
+		}
+		if _, found := object[key]; !found {
+			keys = append(keys, key)
+		}
+		object[key] = value
+
+		me.skipSpace()
+		switch me.peek() {
+		case ',':
+			me.pos++
+		case '}':
+		default:
+			return nil, me.errorf("missing ',' after '%s'", key)
+		}
+	}
+}
+
+func (me *jsParser) array() (interface{}, error) {
+	array := []interface{}{}
+	me.pos++
+
+	for {
+		me.skipSpace()
+		if me.peek() == ']' {
+			me.pos++
+			return array, nil
+		}
+
+		value, err := me.value()
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, value)
+
+		me.skipSpace()
+		switch me.peek() {
+		case ',':
+			me.pos++
+		case ']':
+		default:
+			return nil, me.errorf("missing ',' in array")
+		}
+	}
+}
+
+func (me *jsParser) str() (interface{}, error) {
+	quote := me.src[me.pos]
+	var sb strings.Builder
+
+	for me.pos++;  me.pos < len(me.src);  me.pos++ {
+		c := me.src[me.pos]
+		switch {
+		case c == quote:
+			me.pos++
+			return sb.String(), nil
+		case c == '\\' && me.pos + 1 < len(me.src):
+			me.pos++
+			switch e := me.src[me.pos]; e {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case 'r':
+				sb.WriteByte('\r')
+			case 'u':
+				if me.pos + 4 < len(me.src) {
+					if r, err := strconv.ParseUint(me.src[me.pos+1:me.pos+5], 16, 32); err == nil {
+						sb.WriteRune(rune(r))
+						me.pos += 4
+						continue
+					}
+				}
+				sb.WriteByte(e)
+			default:
+				sb.WriteByte(e)
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+
+	return nil, me.errorf("unterminated string")
+}
+
+// number reads a numeric literal, decimal or hexadecimal. Something else
+// starting like one, eg. '-limit' or '1-2', leaves the position alone and
+// reads as nil for value to skip.
+func (me *jsParser) number() (interface{}, error) {
+	start := me.pos
+	digits := func(hex bool) int {
+		n := 0
+		for ;  me.pos < len(me.src);  me.pos++ {
+			c := me.src[me.pos]
+			if (c < '0' || c > '9') && (!hex || ((c < 'a' || c > 'f') && (c < 'A' || c > 'F'))) {
+				break
+			}
+			n++
+		}
+		return n
+	}
+
+	if me.peek() == '-' {
+		me.pos++
+	}
+
+	var n int
+	if rest := me.src[me.pos:]; strings.HasPrefix(rest, "0x") || strings.HasPrefix(rest, "0X") {
+		me.pos += 2
+		n = digits(true)
+	} else {
+		n = digits(false)
+		if me.peek() == '.' {
+			me.pos++
+			n += digits(false)
+		}
+		if c := me.peek(); n > 0 && (c == 'e' || c == 'E') {
+			mantissa := me.pos
+			me.pos++
+			if c := me.peek(); c == '+' || c == '-' {
+				me.pos++
+			}
+			if digits(false) == 0 {
+				me.pos = mantissa
+			}
+		}
+	}
+	if n == 0 {
+		me.pos = start
+		return nil, nil
+	}
+
+	text := me.src[start:me.pos]
+	if i, err := strconv.ParseInt(text, 0, 64); err == nil {
+		return float64(i), nil
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, me.errorf("invalid number '%s'", text)
+	}
+
+	return f, nil
+}
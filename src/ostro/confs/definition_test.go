@@ -0,0 +1,119 @@
+package confs
+
+import (
+	"reflect"
+	"testing"
+)
+
+// plain drops the key order of the parsed objects.
+func plain(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		object := map[string]interface{}{}
+		for key, item := range v {
+			if key != keyOrder {
+				object[key] = plain(item)
+			}
+		}
+		return object
+	case []interface{}:
+		array := []interface{}{}
+		for _, item := range v {
+			array = append(array, plain(item))
+		}
+		return array
+	case Definition:
+		return plain(map[string]interface{}(v))
+	}
+	return value
+}
+
+func TestParseDefinition(t *testing.T) {
+	for _, c := range []struct{
+		name string
+		src string
+		def map[string]interface{}
+	}{
+		{"comments", `// pageDef = {"wrong": 1}
+			/* pageDef = {} */
+			var pageDef = { // the page
+				a: 1, /* inline, } */ b: "x // not a comment"
+			};`,
+			map[string]interface{}{"a": float64(1), "b": "x // not a comment"}},
+		{"mentions", `var s = "pageDef = {wrong: 1}", mypageDef = {wrong: 2};
+			pageDef = {a: 1}`,
+			map[string]interface{}{"a": float64(1)}},
+		{"keys", `pageDef = {"quoted": 1, 'single': 2, bare_$1: 3, "with space": 4}`,
+			map[string]interface{}{"quoted": float64(1), "single": float64(2), "bare_$1": float64(3), "with space": float64(4)}},
+		{"trailing commas", `pageDef = {list: [1, 2,], nested: {a: true,},}`,
+			map[string]interface{}{"list": []interface{}{float64(1), float64(2)}, "nested": map[string]interface{}{"a": true}}},
+		{"strings", `pageDef = {s: 'it\'s', d: "a\"b\nA"}`,
+			map[string]interface{}{"s": "it's", "d": "a\"b\nA"}},
+		{"literals", `pageDef = {t: true, f: false, n: null, u: undefined}`,
+			map[string]interface{}{"t": true, "f": false, "n": nil, "u": nil}},
+		{"numbers", `pageDef = {hex: 0x1F, upper: 0XfF, neg: -42, negHex: -0x10, frac: .5, exp: 1.5e3, negExp: -2E-2}`,
+			map[string]interface{}{"hex": float64(31), "upper": float64(255), "neg": float64(-42), "negHex": float64(-16),
+				"frac": 0.5, "exp": float64(1500), "negExp": -0.02}},
+		{"functions and expressions", `pageDef = {
+				onChange: function(v) { return {x: v}; },
+				arrow: (a, b) => a + b,
+				call: check("a,b", [1, 2]),
+				concat: "a" + "b",
+				regexp: /[a-z\/]+,/g,
+				method: /,/.test,
+				diff: 1-2,
+				sum: 0x10+1,
+				negative: -limit,
+				product: 2 * count,
+				after: 3
+			}`,
+			map[string]interface{}{"onChange": nil, "arrow": nil, "call": nil, "concat": nil, "regexp": nil, "method": nil,
+				"diff": nil, "sum": nil, "negative": nil, "product": nil, "after": float64(3)}},
+		{"assignment after a comparison", `if (pageDef == null) pageDef = {a: [{b: -1}]}`,
+			map[string]interface{}{"a": []interface{}{map[string]interface{}{"b": float64(-1)}}}},
+	} {
+		def, err := ParseDefinition(c.src)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		} else if got := plain(def); !reflect.DeepEqual(got, c.def) {
+			t.Errorf("%s: got %v, expected %v", c.name, got, c.def)
+		}
+	}
+}
+
+func TestDefinitionKeyOrder(t *testing.T) {
+	def, err := ParseDefinition(`pageDef = {zeta: 1, alpha: {c: 1, b: 2}, mid: 3, zeta: 4}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if keys := Keys(def); !reflect.DeepEqual(keys, []string{"zeta", "alpha", "mid"}) {
+		t.Errorf("got keys %v", keys)
+	}
+	if keys := Keys(def["alpha"].(map[string]interface{})); !reflect.DeepEqual(keys, []string{"c", "b"}) {
+		t.Errorf("got nested keys %v", keys)
+	}
+	if keys := Keys(map[string]interface{}{"b": 1, "a": 2}); !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Errorf("got keys %v of an object of another origin", keys)
+	}
+}
+
+func TestParseDefinitionErrors(t *testing.T) {
+	for _, src := range []string{
+		`var page = {}`,
+		`// pageDef = {}`,
+		`mypageDef = {}`,
+		`pageDef = {a: 1}.filter(f)`,
+		`pageDef = [1, 2]`,
+		`pageDef = {a: 1`,
+		`pageDef = {a 1}`,
+		`pageDef = {a: 1; b: 2}`,
+		`pageDef = {a: "open}`,
+		`pageDef = {: 1}`,
+		`pageDef = {a: f(}`,
+	} {
+		if def, err := ParseDefinition(src); err == nil {
+			t.Errorf("'%s' parsed as %v", src, def)
+		}
+	}
+}
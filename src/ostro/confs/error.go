@@ -2,15 +2,17 @@ package confs
 
 import (
 	"fmt"
+	"errors"
 )
 
 type Error struct {
 	text string
 	path string
+	err error
 }
 
 func newError(err error, path string) *Error {
-	return &Error{ text: err.Error(), path: path }
+	return &Error{ text: err.Error(), path: path, err: err }
 }
 
 func (me *Error) Error() string {
@@ -21,6 +23,28 @@ func (me *Error) Path() string {
 	return me.path
 }
 
+func (me *Error) Unwrap() error {
+	return me.err
+}
+
 func (me Error) String() string {
 	return fmt.Sprintf("%s: %s", me.path, me.text)
 }
+
+// IsDefinitionError reports whether there is no definition for the path,
+// ie. it is not a known configuration node.
+func IsDefinitionError(err error) bool {
+	return errors.Is(err, defError)
+}
+
+// IsPathError reports whether the path itself is malformed or outside of
+// the accepted subtrees.
+func IsPathError(err error) bool {
+	return errors.Is(err, nameError) || errors.Is(err, treeError) || errors.Is(err, pathError)
+}
+
+// IsConflictError reports whether the path clashes with the content of
+// the drop zone, like a directory in place of a file or a foreign origin.
+func IsConflictError(err error) bool {
+	return errors.Is(err, fileError) || errors.Is(err, dirError) || errors.Is(err, originError)
+}
@@ -10,6 +10,7 @@ import (
 	"io/ioutil"
 	"crypto/md5"
 	"golang.org/x/sys/unix"
+	"ostro/lifecycle"
 )
 
 const (
@@ -31,6 +32,7 @@ var (
 	defError    = errors.New("definition file is missing or invalid")
 	lengthError = errors.New("only part could be written")
 	hashError   = errors.New("invalid file hash")
+	stopError   = errors.New("shutting down")
 )
 
 
@@ -74,7 +76,7 @@ func checkDirPath(subtree string, dirs []string, create bool) (bool, *Error) {
 		dir += ("/" + dirs[i])
 		dropPath := fmt.Sprintf("%s/%s%s", dropZone, subtree, dir)
 		if dir == "/" {
-			jsFile = fmt.Sprintf("%s/root.js")
+			jsFile = fmt.Sprintf("%s/root.js", defRoot)
 		} else {
 			jsFile = fmt.Sprintf("%s%s.js", defRoot, dir)
 		}
@@ -222,6 +224,11 @@ func writeFile(dropPath string, content []byte, origin string) *Error {
 		size int
 	)
 
+	if !lifecycle.Begin() {
+		return newError(stopError, dropPath)
+	}
+	defer lifecycle.End()
+
 	hash := md5.Sum(content)
 
 	if !force {
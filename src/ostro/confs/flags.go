@@ -24,6 +24,7 @@ var (
 	dropZone    = "/var/cache/confs"
 	tmpDir      = "/var/cache/confs/tmp"
 	defRoot     = "/usr/share/confs/ui"
+	historyFile = "/var/lib/confs/history"
 	subTrees    = map[string]bool{"local":true, "common":true}
 	checkOrigin = false
 	allowOrigin = map[string]bool{}
@@ -36,10 +37,16 @@ func init() {
 	flag.BoolVar(&force, "force", force, "if true, files will be overwritten with identical content")
 	flag.StringVar(&dropZone, "drop-zone", dropZone, "root directory DropZone")
 	flag.StringVar(&defRoot, "definition-root", defRoot, "root directory of definitions")
+	flag.StringVar(&historyFile, "history-file", historyFile, "where factory resets are recorded")
 	flag.StringVar(&originList, "allow-origin", originList, "comma separated list of origins to share with or '*'")
 }
 
 
+// DropZone is the root the paths are validated and written under.
+func DropZone() string {
+	return dropZone
+}
+
 func Initialize(origin string) error {
 	var err error = nil
 
@@ -82,26 +82,35 @@ func (me *ConfFragment) WriteDropZone() *Error {
 }
 
 func (me *ConfFragment) ExportDropZone(Type string) *Error {
-	return me.traverseFragmentAndExport(Type, me.path, me.content)
+	return me.traverseFragmentAndExport(Type, me.path, me.content, true)
+}
+
+// CheckDropZone does the checks of WriteDropZone without writing or
+// creating anything.
+func (me *ConfFragment) CheckDropZone() *Error {
+	return me.traverseFragmentAndExport(me.typ, me.path, me.content, false)
 }
 	
-func (me *ConfFragment) traverseFragmentAndExport(Type, confsPath string, tree map[string]interface{}) *Error {
+func (me *ConfFragment) traverseFragmentAndExport(Type, confsPath string, tree map[string]interface{}, write bool) *Error {
 	var ferr *Error
 	var dropPath string
 	
-	if dropPath, ferr = CheckFilePath(confsPath, true); ferr == nil {
+	if dropPath, ferr = CheckFilePath(confsPath, write); ferr == nil {
 		var content []byte
 		var cerr error
 
 		if content, cerr = me.ExportBytes(Type, Pretty); cerr != nil {
 			return newError(cerr, me.path)
 		}
+		if !write {
+			return nil
+		}
 		if err := writeFile(dropPath, content, me.origin); err != nil {
 			return err
 		}
 		return nil
 	}
-	if _, err := CheckDirPath(confsPath, true); err != nil {
+	if _, err := CheckDirPath(confsPath, write); err != nil {
 		return ferr
 	}
 	for name, value := range tree {
@@ -109,7 +118,7 @@ func (me *ConfFragment) traverseFragmentAndExport(Type, confsPath string, tree m
 		if reflect.ValueOf(value).Kind() != reflect.Map {
 			return newError(mapError, extendedPath)
 		}
-		err := me.traverseFragmentAndExport(Type, extendedPath, tree[name].(map[string]interface{}))
+		err := me.traverseFragmentAndExport(Type, extendedPath, tree[name].(map[string]interface{}), write)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,307 @@
+package confs
+
+import (
+	"fmt"
+	"errors"
+	"sort"
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+func sortedKeys(value map[string]interface{}) []string {
+	keys := make([]string, 0, len(value))
+
+	for key := range value {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+var (
+	iniError = errors.New("value can't be written as INI")
+)
+
+// IsIniError reports whether a value has no INI form that reads back as
+// the same value.
+func IsIniError(err error) bool {
+	return errors.Is(err, iniError)
+}
+
+// MarshalIni writes the leaves of an object as 'key = value' lines under
+// a section named after the dotted path of their parent object. Array
+// items are written as 'key[] = item' lines, an empty array as a bare
+// 'key[] ='. Dots and the other characters of the syntax are escaped in
+// the names with a backslash.
+func MarshalIni(value map[string]interface{}, pretty bool) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if err := marshalIniSection(&buf, "", value, pretty); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func marshalIniSection(buf *bytes.Buffer, section string, value map[string]interface{}, pretty bool) error {
+	sections := []string{}
+
+	if section != "" {
+		if pretty && buf.Len() > 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(buf, "[%s]\n", section)
+	}
+
+	for _, key := range sortedKeys(value) {
+		name, err := escapeIniName(key)
+		if err != nil {
+			return err
+		}
+
+		switch v := value[key].(type) {
+		case map[string]interface{}:
+			sections = append(sections, key)
+		case []interface{}:
+			if len(v) == 0 {
+				fmt.Fprintf(buf, "%s[] =\n", name)
+			}
+			for _, item := range v {
+				if err := marshalIniLeaf(buf, name + "[]", item); err != nil {
+					return err
+				}
+			}
+		default:
+			if err := marshalIniLeaf(buf, name, v); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, key := range sections {
+		name, _ := escapeIniName(key)
+		if section != "" {
+			name = section + "." + name
+		}
+		if err := marshalIniSection(buf, name, value[key].(map[string]interface{}), pretty); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// escapeIniName escapes the characters of a key that the syntax gives a
+// meaning. Keys that would not survive the trimming of the lines are
+// rejected.
+func escapeIniName(key string) (string, error) {
+	if key == "" || strings.TrimSpace(key) != key || strings.ContainsAny(key, "\n\r") {
+		return "", fmt.Errorf("key '%s': %w", key, iniError)
+	}
+
+	var buf strings.Builder
+	for _, c := range key {
+		if strings.ContainsRune(iniSpecials, c) {
+			buf.WriteByte('\\')
+		}
+		buf.WriteRune(c)
+	}
+
+	return buf.String(), nil
+}
+
+const iniSpecials = "\\.=[]#;\""
+
+// unescapeIniName is the counterpart of escapeIniName. It splits the
+// name at the unescaped occurrences of sep, if not 0, and tells whether
+// the last part ends with the array marker '[]'.
+func unescapeIniName(name string, sep rune) ([]string, bool, error) {
+	parts := []string{}
+	array := false
+
+	var buf strings.Builder
+	escaped := false
+	runes := []rune(name)
+
+	for i, c := range runes {
+		switch {
+		case escaped:
+			buf.WriteRune(c)
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case sep != 0 && c == sep:
+			parts = append(parts, strings.TrimSpace(buf.String()))
+			buf.Reset()
+		case c == '[' && i == len(runes) - 2 && runes[i+1] == ']':
+			array = true
+			parts = append(parts, strings.TrimSpace(buf.String()))
+			return parts, array, nil
+		case strings.ContainsRune(iniSpecials, c):
+			return nil, false, fmt.Errorf("unescaped '%c' in '%s'", c, name)
+		default:
+			buf.WriteRune(c)
+		}
+	}
+	if escaped {
+		return nil, false, fmt.Errorf("trailing '\\' in '%s'", name)
+	}
+
+	return append(parts, strings.TrimSpace(buf.String())), array, nil
+}
+
+func marshalIniLeaf(buf *bytes.Buffer, key string, value interface{}) error {
+	var s string
+
+	switch v := value.(type) {
+	case map[string]interface{}, []interface{}:
+		return fmt.Errorf("'%s': nested %w", key, iniError)
+	case nil:
+		s = ""
+	case string:
+		s = v
+		// strings that would read back as another value, like "123",
+		// "true" or "", are quoted as well
+		if parsed, err := parseIniValue(s); s == "" || err != nil || parsed != interface{}(s) ||
+			strings.ContainsAny(s, "\n\r;#\"") || strings.TrimSpace(s) != s {
+			s = fmt.Sprintf("%q", s)
+		}
+	default:
+		s = fmt.Sprintf("%v", v)
+	}
+
+	fmt.Fprintf(buf, "%s = %s\n", key, s)
+
+	return nil
+}
+
+// UnmarshalIni is the counterpart of MarshalIni. Keys marked with '[]'
+// and repeated keys become arrays; unquoted values that look like
+// booleans or numbers are converted, quoted ones stay strings.
+func UnmarshalIni(data []byte) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	section := result
+
+	for lineno, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' {
+			if line[len(line)-1] != ']' {
+				return nil, fmt.Errorf("line %d: malformed section", lineno + 1)
+			}
+			names, array, err := unescapeIniName(line[1:len(line)-1], '.')
+			if err != nil || array {
+				return nil, fmt.Errorf("line %d: malformed section", lineno + 1)
+			}
+			section = result
+			for _, name := range names {
+				if name == "" {
+					return nil, fmt.Errorf("line %d: empty section name", lineno + 1)
+				}
+				sub, ok := section[name].(map[string]interface{})
+				if !ok {
+					if _, found := section[name]; found {
+						return nil, fmt.Errorf("line %d: '%s' is not a section", lineno + 1, name)
+					}
+					sub = make(map[string]interface{})
+					section[name] = sub
+				}
+				section = sub
+			}
+			continue
+		}
+
+		eq := unescapedIndex(line, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expected 'key = value'", lineno + 1)
+		}
+		names, array, err := unescapeIniName(strings.TrimSpace(line[:eq]), 0)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineno + 1, err)
+		}
+		key := names[0]
+		if key == "" {
+			return nil, fmt.Errorf("line %d: expected 'key = value'", lineno + 1)
+		}
+		raw := strings.TrimSpace(line[eq+1:])
+
+		if _, ok := section[key].(map[string]interface{}); ok {
+			return nil, fmt.Errorf("line %d: '%s' is a section", lineno + 1, key)
+		}
+
+		if array {
+			current, ok := section[key].([]interface{})
+			if !ok {
+				if _, found := section[key]; found {
+					return nil, fmt.Errorf("line %d: '%s' is not an array", lineno + 1, key)
+				}
+				current = []interface{}{}
+			}
+			// a bare 'key[] =' declares the array without an item
+			if raw != "" {
+				value, err := parseIniValue(raw)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", lineno + 1, err)
+				}
+				current = append(current, value)
+			}
+			section[key] = current
+			continue
+		}
+
+		value, err := parseIniValue(raw)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineno + 1, err)
+		}
+
+		switch current := section[key].(type) {
+		case nil:
+			section[key] = value
+		case []interface{}:
+			section[key] = append(current, value)
+		default:
+			section[key] = []interface{}{current, value}
+		}
+	}
+
+	return result, nil
+}
+
+// unescapedIndex returns the index of the first c not escaped by a
+// backslash, or -1.
+func unescapedIndex(s string, c byte) int {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case c:
+			return i
+		}
+	}
+	return -1
+}
+
+func parseIniValue(s string) (interface{}, error) {
+	if strings.HasPrefix(s, "\"") {
+		return strconv.Unquote(s)
+	}
+
+	switch s {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, nil
+	}
+
+	return s, nil
+}
@@ -0,0 +1,89 @@
+package confs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIniRoundTrip(t *testing.T) {
+	value := map[string]interface{}{
+		"name": "wifi",
+		"id": "123",
+		"ratio": "1.5",
+		"exp": "1e3",
+		"on": "true",
+		"off": "false",
+		"spaced": " padded ",
+		"comment": "a;b",
+		"quote": "say \"hi\"",
+		"count": float64(123),
+		"enabled": true,
+		"empty": "",
+		"list": []interface{}{"1", float64(2), false, ""},
+		"single": []interface{}{"one"},
+		"none": []interface{}{},
+		"a.b": "dotted",
+		"k=v": "equals",
+		"#hash": ";semi",
+		"[x]": "brackets",
+		"back\\slash": "\\",
+		"net": map[string]interface{}{
+			"port": "8080",
+			"mtu": float64(1500),
+			"if.eth0": map[string]interface{}{
+				"addrs": []interface{}{"10.0.0.2"}}},
+		"net.if": map[string]interface{}{
+			"eth0": "not nested"}}
+
+	content, err := MarshalIni(value, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := UnmarshalIni(content)
+	if err != nil {
+		t.Fatalf("%v in\n%s", err, content)
+	}
+
+	if !reflect.DeepEqual(parsed, value) {
+		t.Errorf("round trip changed the values:\n%s\ngot %#v", content, parsed)
+	}
+}
+
+func TestIniRepeatedKeys(t *testing.T) {
+	parsed, err := UnmarshalIni([]byte("dns = 10.0.0.1\ndns = 10.0.0.2\n[wifi]\nssid = home\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"dns": []interface{}{"10.0.0.1", "10.0.0.2"},
+		"wifi": map[string]interface{}{"ssid": "home"}}
+	if !reflect.DeepEqual(parsed, expected) {
+		t.Errorf("got %#v", parsed)
+	}
+}
+
+func TestIniRejects(t *testing.T) {
+	for _, value := range []map[string]interface{}{
+		{"list": []interface{}{[]interface{}{"nested"}}},
+		{"list": []interface{}{map[string]interface{}{"a": "b"}}},
+		{" padded": "x"},
+		{"": "x"},
+		{"multi\nline": "x"},
+	} {
+		if content, err := MarshalIni(value, false); !IsIniError(err) {
+			t.Errorf("%#v written as\n%s", value, content)
+		}
+	}
+
+	for _, content := range []string{
+		"a]b = 1\n",
+		"[a[]]\n",
+		"x = 1\nx[] = 2\n",
+	} {
+		if parsed, err := UnmarshalIni([]byte(content)); err == nil {
+			t.Errorf("'%s' read as %#v", content, parsed)
+		}
+	}
+}
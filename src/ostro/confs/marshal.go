@@ -27,7 +27,7 @@ func Marshal(Path string, Type string, Value map[string]interface{}, pretty bool
 	} else {
 		switch Type {
 		case "ini":
-			fallthrough
+			buf, err = MarshalIni(Value, pretty)
 		case "json":
 			if pretty {
 				buf, err = json.MarshalIndent(Value, "", "    ")
@@ -35,14 +35,9 @@ func Marshal(Path string, Type string, Value map[string]interface{}, pretty bool
 				buf, err = json.Marshal(Value)
 			}
 		case "xml":
-			xmlContent := make(map[string]interface{})
-			xmlContent[path.Base(Path)] = Value
-			if pretty {
-				buf, err = xml.MarshalIndent(xmlContent, "", "    ")
-			} else {
-				buf, err = xml.Marshal(xmlContent)
+			if buf, err = MarshalXmlObject(path.Base(Path), Value, pretty); err == nil {
+				buf = append([]byte(xml.Header), buf...)
 			}
-			buf = append([]byte(xml.Header), buf...)
 		default:
 			err = formatError
 		}
@@ -52,6 +47,55 @@ func Marshal(Path string, Type string, Value map[string]interface{}, pretty bool
 }
 
 
+// UnmarshalType parses data of a known type; an empty type is detected
+// from the content as Unmarshal does.
+func UnmarshalType(Path string, Type string, Data []byte) (map[string]interface{}, error) {
+	var value map[string]interface{}
+	var err error
+
+	trimmedData := bytes.Trim(Data, " \t\n\r\f")
+
+	if Type == "" {
+		_, value, err = Unmarshal(Path, Data)
+		return value, err
+	}
+
+	if !IsValidPath(Path) {
+		return nil, nameError
+	}
+	if len(trimmedData) < 2 || len(trimmedData) > 65536 {
+		return nil, sizeError
+	}
+
+	switch Type {
+	case "json":
+		value = make(map[string]interface{})
+		err = json.Unmarshal(trimmedData, &value)
+	case "xml":
+		var xmlValue map[string]interface{}
+		var ok bool
+		if !HasValidXmlProlog(string(trimmedData)) {
+			return nil, formatError
+		}
+		if xmlValue, err = UnmarshalXmlObject(string(trimmedData)); err == nil {
+			if value, ok = xmlValue[path.Base(Path)].(map[string]interface{}); !ok {
+				err = formatError
+			}
+		}
+	case "ini":
+		value, err = UnmarshalIni(trimmedData)
+	default:
+		err = formatError
+	}
+
+	return value, err
+}
+
+// IsSizeError reports whether the data was rejected for its size.
+func IsSizeError(err error) bool {
+	return err == sizeError
+}
+
 func Unmarshal(Path string, Data []byte) (string, map[string]interface{}, error) {
 	var value map[string]interface{}
 	var xmlValue map[string]interface{}
@@ -0,0 +1,195 @@
+package confs
+
+import (
+	"os"
+	"sort"
+	"sync"
+	"time"
+	"strings"
+	"path/filepath"
+	"encoding/json"
+	"ostro/lifecycle"
+)
+
+var (
+	resetMutex  sync.Mutex
+	resetHooks  []func(*ResetRecord)
+)
+
+type ResetOptions struct {
+	Common bool		// wipe the common subtree as well as the local one
+	Scopes []string		// nodes to reset, like /network; everything if empty
+	Origin string		// who asked for it, goes to the history
+	DryRun bool		// only tell what would be removed
+}
+
+// ResetRecord is what a factory reset did. It goes to the history and to
+// the hooks registered with OnReset.
+type ResetRecord struct {
+	Time time.Time `json:"time"`
+	Origin string `json:"origin,omitempty"`
+	Subtrees []string `json:"subtrees"`
+	Scopes []string `json:"scopes"`
+	Removed []string `json:"removed"`
+}
+
+// OnReset registers a hook that is called after every factory reset of
+// the process, eg. to tell the clients to re-read their configuration.
+func OnReset(hook func(*ResetRecord)) {
+	resetMutex.Lock()
+	defer resetMutex.Unlock()
+
+	resetHooks = append(resetHooks, hook)
+}
+
+// FactoryReset removes the files of the local and, if asked, the common
+// subtree, so that the factory values take effect again. The factory
+// subtree is never touched. Removals show up for the drop zone watchers;
+// the reset itself is appended to the history and passed to the hooks.
+func FactoryReset(opts ResetOptions) (*ResetRecord, *Error) {
+	subtrees := []string{"local"}
+	if opts.Common {
+		subtrees = append(subtrees, "common")
+	}
+
+	scopes := []string{}
+	for _, scope := range opts.Scopes {
+		scope = "/" + strings.Trim(scope, "/")
+		if !IsValidPath(scope) {
+			return nil, newError(nameError, scope)
+		}
+		if scope == "/" {
+			scopes = []string{"/"}
+			break
+		}
+		scopes = append(scopes, scope)
+	}
+	if len(scopes) == 0 {
+		scopes = []string{"/"}
+	}
+
+	record := &ResetRecord{
+		Time: time.Now().UTC(),
+		Origin: opts.Origin,
+		Subtrees: subtrees,
+		Scopes: scopes,
+		Removed: []string{}}
+
+	// scopes may overlap, like /network and /network/wifi
+	seen := map[string]bool{}
+	files := []string{}
+	for _, subtree := range subtrees {
+		for _, scope := range scopes {
+			found, err := scopeFiles(subtree, scope)
+			if err != nil {
+				return nil, err
+			}
+			for _, file := range found {
+				if !seen[file] {
+					seen[file] = true
+					files = append(files, file)
+				}
+			}
+		}
+	}
+	sort.Strings(files)
+
+	if opts.DryRun {
+		for _, file := range files {
+			record.Removed = append(record.Removed, strings.TrimPrefix(file, dropZone))
+		}
+		return record, nil
+	}
+
+	if !lifecycle.Begin() {
+		return nil, newError(stopError, dropZone)
+	}
+	defer lifecycle.End()
+
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return record, newError(err, file)
+		}
+		record.Removed = append(record.Removed, strings.TrimPrefix(file, dropZone))
+		removeEmptyDirs(filepath.Dir(file))
+	}
+
+	Infof("factory reset of %s (%s) by '%s' removed %d file(s)",
+		strings.Join(subtrees, ","), strings.Join(scopes, ","), opts.Origin, len(record.Removed))
+
+	if err := appendHistory(record); err != nil {
+		Errorf("failed to record factory reset in '%s': %v", historyFile, err)
+	}
+
+	resetMutex.Lock()
+	hooks := resetHooks
+	resetMutex.Unlock()
+
+	for _, hook := range hooks {
+		hook(record)
+	}
+
+	return record, nil
+}
+
+func scopeFiles(subtree, scope string) ([]string, *Error) {
+	root := dropZone + "/" + subtree
+	files := []string{}
+
+	start := root + strings.TrimRight(scope, "/")
+	if _, err := os.Lstat(start); os.IsNotExist(err) {
+		return files, nil
+	}
+
+	err := filepath.Walk(start, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, newError(err, start)
+	}
+
+	return files, nil
+}
+
+// removeEmptyDirs removes dir and its parents while they are empty, up to
+// but not including the subtree roots.
+func removeEmptyDirs(dir string) {
+	for strings.Count(strings.TrimPrefix(dir, dropZone), "/") > 1 {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+func appendHistory(record *ResetRecord) error {
+	line, err := json.Marshal(struct{
+		Event string `json:"event"`
+		*ResetRecord
+	}{"factory-reset", record})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(historyFile), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(historyFile, os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
@@ -265,3 +265,54 @@ func UnmarshalXmlArray(xmlString string) ([]interface{}, error) {
 
 	return result, nil
 }
+
+// MarshalXmlObject is the counterpart of UnmarshalXmlObject: objects become
+// nested elements, arrays repeated elements and everything else character
+// data. encoding/xml has no support for maps.
+func MarshalXmlObject(name string, value map[string]interface{}, pretty bool) ([]byte, error) {
+	var buf bytes.Buffer
+
+	e := xml.NewEncoder(&buf)
+	if pretty {
+		e.Indent("", "    ")
+	}
+
+	if err := marshalXmlValue(e, name, value); err != nil {
+		return nil, err
+	}
+	if err := e.Flush(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func marshalXmlValue(e *xml.Encoder, name string, value interface{}) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		for _, key := range sortedKeys(v) {
+			if err := marshalXmlValue(e, key, v[key]); err != nil {
+				return err
+			}
+		}
+		return e.EncodeToken(start.End())
+
+	case []interface{}:
+		for _, item := range v {
+			if err := marshalXmlValue(e, name, item); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case nil:
+		return e.EncodeElement("", start)
+	}
+
+	return e.EncodeElement(fmt.Sprintf("%v", value), start)
+}
@@ -0,0 +1,132 @@
+package confui
+
+import (
+	"os"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"bytes"
+	"io/fs"
+	"strings"
+	"net/url"
+	"net/http"
+	"io/ioutil"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+const (
+	// for URLs carrying the hash of the content, which never changes
+	immutableCache = "public, max-age=31536000, immutable"
+	revalidateCache = "no-cache"
+)
+
+var (
+	// the infra assets of the binary, if built with them; see assets_embed.go
+	embeddedAssets fs.FS
+
+	// so the binary's own assets have a time for If-Modified-Since
+	startTime = time.Now()
+
+	assetMutex sync.Mutex
+	assets = map[string]*asset{}
+)
+
+type asset struct {
+	name string
+	content []byte
+	hash string
+	modTime time.Time
+	size int64
+	embedded bool
+}
+
+// findAsset returns the file of the UI prefix at relPath, like
+// /infra/formgen.js, or else the one compiled into the binary. Files are
+// read again when they change on disk.
+func findAsset(relPath string) (*asset, error) {
+	filePath := fmt.Sprintf("%s%s", uiServer.prefix, relPath)
+
+	assetMutex.Lock()
+	defer assetMutex.Unlock()
+
+	cached := assets[relPath]
+
+	info, err := os.Stat(filePath)
+	if err == nil && info.Mode().IsRegular() {
+		if cached != nil && !cached.embedded && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+			return cached, nil
+		}
+
+		content, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+
+		a := newAsset(relPath, content, info.ModTime(), false)
+		a.size = info.Size()
+		assets[relPath] = a
+
+		return a, nil
+	}
+
+	if cached != nil && cached.embedded {
+		return cached, nil
+	}
+
+	if embeddedAssets != nil && strings.HasPrefix(relPath, "/infra/") {
+		if content, err := fs.ReadFile(embeddedAssets, strings.TrimPrefix(relPath, "/infra/")); err == nil {
+			a := newAsset(relPath, content, startTime, true)
+			assets[relPath] = a
+			return a, nil
+		}
+	}
+
+	delete(assets, relPath)
+	if err == nil {
+		err = fmt.Errorf("'%s' is not a regular file", filePath)
+	}
+
+	return nil, err
+}
+
+func newAsset(relPath string, content []byte, modTime time.Time, embedded bool) *asset {
+	sum := sha256.Sum256(content)
+
+	return &asset{
+		name: relPath,
+		content: content,
+		hash: hex.EncodeToString(sum[:8]),
+		modTime: modTime,
+		embedded: embedded}
+}
+
+// assetURL is the URL of the asset, versioned by its content when found.
+func assetURL(relPath string) string {
+	u := uiServer.pattern + relPath
+
+	if a, err := findAsset(relPath); err == nil {
+		u += "?v=" + url.QueryEscape(a.hash)
+	}
+
+	return u
+}
+
+func serveAsset(w http.ResponseWriter, r *http.Request, relPath string) {
+	a, err := findAsset(relPath)
+	if err != nil {
+		log.Printf("can't serve '%s': %v\n", relPath, err)
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("ETag", "\"" + a.hash + "\"")
+	if r.URL.Query().Get("v") == a.hash {
+		w.Header().Set("Cache-Control", immutableCache)
+	} else {
+		w.Header().Set("Cache-Control", revalidateCache)
+	}
+
+	http.ServeContent(w, r, a.name, a.modTime, bytes.NewReader(a.content))
+}
@@ -0,0 +1,16 @@
+//go:build embedui
+// +build embedui
+
+package confui
+
+import (
+	"embed"
+)
+
+// the infra assets compiled in with -tags embedui
+//go:embed toolgen.js formgen.js dirgen.js page.css
+var embeddedFiles embed.FS
+
+func init() {
+	embeddedAssets = embeddedFiles
+}
@@ -1,30 +1,71 @@
 package confui
 
 import (
+  "context"
   "crypto/tls"
 	"fmt"
+	"html"
+	"encoding/json"
 	"log"
 	"os"
-  "strconv"
 	"strings"
-  "syscall"
   "net"
+	"net/url"
 	"net/http"
+	"time"
+	"ostro/lifecycle"
+	"ostro/activation"
+	"ostro/cors"
+	"ostro/confs"
+	"ostro/session"
+	"ostro/tlscert"
 )
 
 const (
 	htmlTemplate= `<!DOCTYPE html>
-<html>
+<html lang="%[10]s">
   <head>
     <meta name="viewport" content="width=device-width, initial-scale=1.0">
 
-    <script type="text/javascript" src="/confs/infra/toolgen.js"></script>
-    <script type="text/javascript" src="/confs/infra/%sgen.js"></script>
-    <script type="text/javascript" src="/confs%s.js"></script>
+    <script type="text/javascript">
+      uiBaseURL = %[1]s;
+      restBaseURL = %[2]s;
+      csrfToken = %[7]s;
+      uiLabels = %[9]s;
+    </script>
+    <script type="text/javascript" src="%[3]s"></script>
+    <script type="text/javascript" src="%[4]s"></script>
+    <script type="text/javascript" src="%[5]s"></script>
 
-    <link rel="stylesheet" type="text/css" href="/confs/infra/page.css">
+    <link rel="stylesheet" type="text/css" href="%[6]s">
   </head>
   <body>
+    <noscript>%[8]s</noscript>
+  </body>
+</html>
+`
+
+	loginTemplate = `<!DOCTYPE html>
+<html lang="%[8]s">
+  <head>
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>%[7]s</title>
+    <link rel="stylesheet" type="text/css" href="%[1]s">
+  </head>
+  <body>
+    <div class="page">
+      <form class="entry" method="post" action="%[2]s">
+        <div class="container">
+          <p class="message">%[3]s</p>
+          <table class="entry">
+            <tr><td class="label">%[5]s</td><td class="value"><input class="input" type="text" name="user" autocomplete="username" required autofocus></td></tr>
+            <tr><td class="label">%[6]s</td><td class="value"><input class="input" type="password" name="password" autocomplete="current-password" required></td></tr>
+          </table>
+          <input type="hidden" name="next" value="%[4]s">
+        </div>
+        <div class="buttonbar"><div class="buttons"><button type="submit" class="buttonbar">%[7]s</button></div></div>
+      </form>
+    </div>
   </body>
 </html>
 `
@@ -32,65 +73,81 @@ const (
 
 var (
 	uiServer *Server = nil
+	certificate *tlscert.Provider = nil
 )
 
+// SetCertificate makes NewServer use TLS with a self-signed certificate
+// when it gets no certificate files.
+func SetCertificate(provider *tlscert.Provider) {
+	certificate = provider
+}
+
 type Server struct {
 	addr string
 	port int
 	pattern string
 	prefix string
+	restBase string
+	sessions *session.Manager
+	cors *cors.Policy
+	handler http.Handler
 }
 
-func getListenFds() ([]*os.File, error) {
-  pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
-  if err != nil || pid != os.Getpid() {
-    log.Print("Invalid PID set")
-    return nil, err
-  }
+// NewHandler returns the handler of the UI. Pages of other origins may
+// only use it when policy allows; nil allows none. The pages get restBase
+// as the base URL of the REST resources, like /confs; one starting with
+// ':' is a port and path on the host of the page, like :4984/confs. With
+// sessions, the pages need a login.
+func NewHandler(pattern, prefix string, policy *cors.Policy, restBase string, sessions *session.Manager) (http.Handler, error) {
+	if uiServer != nil {
+		if uiServer.pattern != strings.TrimRight(pattern, "/") || uiServer.prefix != prefix || uiServer.restBase != strings.TrimRight(restBase, "/") {
+			return nil, fmt.Errorf("attempt to create multiple confui handlers")
+		}
+		return uiServer.handler, nil
+	}
 
-  nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
-  if  err != nil || nfds == 0 {
-    log.Print("No LISTEN_FDS found")
-    return nil, err
-  }
+	if policy == nil {
+		policy = &cors.Policy{}
+	} else if err := policy.Check(); err != nil {
+		return nil, err
+	}
 
-  log.Print("Number of listen FDs : ", nfds)
+	mux := http.NewServeMux()
+	mux.HandleFunc(pattern, httpRequestHandler)
 
-  files := make([]*os.File, nfds)
-  for fd := 3; fd < 3+nfds; fd++ {
-    syscall.CloseOnExec(fd)
-    files[fd-3] = os.NewFile(uintptr(fd), "")
-  }
+	uiServer = &Server{
+		pattern: strings.TrimRight(pattern, "/"),
+		prefix: prefix,
+		restBase: strings.TrimRight(restBase, "/"),
+		sessions: sessions,
+		cors: policy,
+		handler: mux}
 
-  return files, nil
+	return mux, nil
 }
 
-func NewServer(addr string, port int, pattern, prefix, certFile, keyFile string) error {
-	if uiServer == nil {
-		uiServer = &Server{
-			addr: addr,
-			port: port,
-			pattern: strings.TrimRight(pattern, "/"),
-			prefix: prefix}
-
-		mux := http.NewServeMux()
-		mux.HandleFunc(pattern, httpRequestHandler)
-
-    var ln net.Listener = nil
-    listen_fds, err := getListenFds()
-    if err == nil && len(listen_fds) != 0 {
-      ln, err = net.FileListener(listen_fds[0])
-      if err != nil {
-        fmt.Print(err)
-        ln = nil
-      }
-    } else {
-      log.Print(err);
+// NewServer serves the UI handler on the socket named "ui" passed by
+// systemd, or else on the given address.
+func NewServer(addr string, port int, pattern, prefix string, policy *cors.Policy, restBase string, sessions *session.Manager, certFile, keyFile string) error {
+	if uiServer == nil || uiServer.port == 0 {
+		mux, err := NewHandler(pattern, prefix, policy, restBase, sessions)
+		if err != nil {
+			return err
+		}
+
+		uiServer.addr = addr
+		uiServer.port = port
+
+    ln, err := activation.Take("ui", true)
+    if err != nil {
+      log.Print(err)
     }
 
     log.Print("Listener : ", ln);
 
-    if ln != nil && certFile != "" && keyFile != "" {
+    files := certFile != "" && keyFile != ""
+
+    if ln != nil && files {
       cfg := &tls.Config{}
       cfg.Certificates = make([]tls.Certificate, 1)
       cfg.Certificates[0], err = tls.LoadX509KeyPair(certFile, keyFile)
@@ -99,21 +156,35 @@ func NewServer(addr string, port int, pattern, prefix, certFile, keyFile string)
       } else {
         ln = tls.NewListener(ln, cfg);
         if (ln == nil) {
-          log.Fatal("Failed to create tls listener");
+          lifecycle.Fatalf("Failed to create tls listener");
         }
       }
+    } else if ln != nil && certificate != nil {
+      ln = tls.NewListener(ln, &tls.Config{GetCertificate: certificate.GetCertificate})
     }
 
 		srv := &http.Server{
 			Addr: fmt.Sprintf("%s:%d", addr, port),
 			Handler: mux,
-			MaxHeaderBytes: 4096}
+			MaxHeaderBytes: 4096,
+			BaseContext: func(net.Listener) context.Context { return lifecycle.Context() }}
+		if !files && certificate != nil {
+			srv.TLSConfig = &tls.Config{GetCertificate: certificate.GetCertificate}
+		}
+
+		lifecycle.OnShutdown(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+			defer cancel()
+			if err := srv.Shutdown(ctx); err != nil {
+				log.Printf("UI server shutdown: %v\n", err)
+			}
+		})
 
 		go func(s *http.Server) {
       if ln != nil {
         log.Print("Serving...");
         log.Print(s.Serve(ln))
-      } else if certFile != "" && keyFile != "" {
+      } else if files || certificate != nil {
         log.Print("Listen and Serving with certificates...");
         log.Print(s.ListenAndServeTLS(certFile, keyFile))
       } else {
@@ -122,7 +193,7 @@ func NewServer(addr string, port int, pattern, prefix, certFile, keyFile string)
       }
 		}(srv)
 	} else {
-		if uiServer.addr != addr || uiServer.port != port || uiServer.pattern != pattern || uiServer.prefix != prefix {
+		if uiServer.addr != addr || uiServer.port != port || uiServer.pattern != strings.TrimRight(pattern, "/") || uiServer.prefix != prefix {
 			return fmt.Errorf("attempt to create multiple confui.Servers")
 		}
 	}
@@ -134,18 +205,46 @@ func httpRequestHandler(w http.ResponseWriter, r *http.Request) {
 	relPath := strings.TrimRight(strings.TrimPrefix(r.URL.Path, uiServer.pattern), "/")
 	log.Printf("**** http: '%s'\n", r.URL.Path)
 
+	if uiServer.cors.Handle(w, r) {
+		return
+	}
+
+	if uiServer.sessions != nil {
+		switch relPath {
+		case "/login":
+			loginHandler(w, r)
+			return
+		case "/logout":
+			logoutHandler(w, r)
+			return
+		}
+	}
+
 	if strings.HasPrefix(relPath, "/infra") || strings.HasSuffix(relPath, ".js") {
-		filePath := fmt.Sprintf("%s%s", uiServer.prefix, relPath)
-		log.Printf("     serving file '%s'\n", filePath)
-		http.ServeFile(w, r, filePath)
-	} else {
-		generateHtmlResponse(w, r, relPath)
+		log.Printf("     serving file '%s'\n", relPath)
+		serveAsset(w, r, relPath)
+		return
 	}
+
+	var s *session.Session
+	if uiServer.sessions != nil {
+		if s = uiServer.sessions.Lookup(r); s == nil {
+			login := uiServer.pattern + "/login?next=" + url.QueryEscape(r.URL.Path)
+			http.Redirect(w, r, login, http.StatusSeeOther)
+			return
+		}
+	}
+
+	generateHtmlResponse(w, r, relPath, s)
 }
 
-func generateHtmlResponse(w http.ResponseWriter, r *http.Request, relPath string) {
-	var filePath, gen, content string
-		
+func generateHtmlResponse(w http.ResponseWriter, r *http.Request, relPath string, s *session.Session) {
+	var filePath, gen, content, rendered string
+
+	l := newLabels(requestLanguage(w, r))
+	w.Header().Add("Vary", "Accept-Language, Cookie")
+
+	node := relPath
 	if relPath == "" {
 		relPath = "/root"
 		filePath = fmt.Sprintf("%s%s", uiServer.prefix, relPath)
@@ -170,8 +269,137 @@ func generateHtmlResponse(w http.ResponseWriter, r *http.Request, relPath string
 		return
 	}
 
-	content = fmt.Sprintf(htmlTemplate, gen, relPath)
+	if r.Method != "GET" && r.Method != "HEAD" && (r.Method != "POST" || nodeStore == nil) {
+		http.Error(w, fmt.Sprintf("'%s' method not supported", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if nodeStore != nil {
+		def, err := confs.ReadDefinition(jsFile)
+		if err != nil {
+			log.Printf("     can't render '%s': %v\n", jsFile, err)
+		} else if r.Method == "POST" {
+			postForm(w, r, node, def, s, l)
+			return
+		} else {
+			page := renderPage(node, def, s, l)
+			if r.URL.Query().Get("saved") != "" && !page.Failed {
+				page.Message = l.Tr("values saved")
+			}
+			if rendered, err = renderContent(page); err != nil {
+				log.Printf("     can't render '%s': %v\n", jsFile, err)
+			}
+		}
+	}
+	if r.Method == "POST" {
+		http.Error(w, "the page can't take forms", http.StatusInternalServerError)
+		return
+	}
+
+	csrfToken := ""
+	if s != nil {
+		csrfToken = s.CSRFToken
+	}
+
+	content = fmt.Sprintf(htmlTemplate,
+		jsString(uiServer.pattern),
+		jsString(uiServer.restBase),
+		html.EscapeString(assetURL("/infra/toolgen.js")),
+		html.EscapeString(assetURL("/infra/" + gen + "gen.js")),
+		html.EscapeString(assetURL(relPath + ".js")),
+		html.EscapeString(assetURL("/infra/page.css")),
+		jsString(csrfToken),
+		rendered,
+		jsObject(l.texts),
+		html.EscapeString(l.Lang))
+
+	// the page names the assets by content, so it must not be cached itself
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if s != nil {
+		w.Header().Set("Cache-Control", "no-store")
+	} else {
+		w.Header().Set("Cache-Control", revalidateCache)
+	}
+	w.Write([]byte(content))
+}
+
+// jsString quotes s for a script element; a JSON string with the HTML
+// special characters escaped is a valid JS string literal.
+func jsString(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
+
+// jsObject is jsString for maps.
+func jsObject(m map[string]string) string {
+	quoted, _ := json.Marshal(m)
+	return string(quoted)
+}
+
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	l := newLabels(requestLanguage(w, r))
+
+	switch r.Method {
+	case "GET", "HEAD":
+		writeLoginPage(w, r.URL.Query().Get("next"), "", http.StatusOK, l)
+	case "POST":
+		next := r.PostFormValue("next")
+
+		s, err := uiServer.sessions.Login(r.PostFormValue("user"), r.PostFormValue("password"))
+		if err != nil {
+			writeLoginPage(w, next, l.Tr("invalid user or password"), http.StatusUnauthorized, l)
+			return
+		}
+
+		uiServer.sessions.SetCookie(w, r, s)
+		http.Redirect(w, r, safeNext(next), http.StatusSeeOther)
+	default:
+		http.Error(w, fmt.Sprintf("'%s' method not supported", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, fmt.Sprintf("'%s' method not supported", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s := uiServer.sessions.Lookup(r); s != nil {
+		if err := session.CheckCSRF(r, s); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		uiServer.sessions.Logout(r)
+	}
+
+	uiServer.sessions.ClearCookie(w, r)
+	http.Redirect(w, r, uiServer.pattern + "/login", http.StatusSeeOther)
+}
 
-	w.Header().Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
+func writeLoginPage(w http.ResponseWriter, next, message string, status int, l *labels) {
+	content := fmt.Sprintf(loginTemplate,
+		html.EscapeString(assetURL("/infra/page.css")),
+		html.EscapeString(uiServer.pattern + "/login"),
+		html.EscapeString(message),
+		html.EscapeString(safeNext(next)),
+		html.EscapeString(l.Tr("user")),
+		html.EscapeString(l.Tr("password")),
+		html.EscapeString(l.Tr("Login")),
+		html.EscapeString(l.Lang))
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
 	w.Write([]byte(content))
 }
+
+// safeNext keeps the redirection after the login within the UI.
+func safeNext(next string) string {
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" || strings.HasPrefix(next, "//") ||
+		(u.Path != uiServer.pattern && !strings.HasPrefix(u.Path, uiServer.pattern + "/")) ||
+		u.Path == uiServer.pattern + "/login" || u.Path == uiServer.pattern + "/logout" {
+		return uiServer.pattern + "/"
+	}
+	return u.Path
+}
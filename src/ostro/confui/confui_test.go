@@ -0,0 +1,39 @@
+package confui
+
+import (
+	"strings"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+	"net/http/httptest"
+	"ostro/cors"
+)
+
+// Without sessions the REST server on the other port answers any origin
+// with '*', so the pages must not ask for credentials.
+func TestNoSessionSendsNoCredentials(t *testing.T) {
+	prefix := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(prefix, "net.js"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := uiServer
+	uiServer = &Server{pattern: "/confs", prefix: prefix, restBase: ":4984/confs", cors: &cors.Policy{}}
+	defer func() {
+		uiServer = saved
+	}()
+
+	w := httptest.NewRecorder()
+	generateHtmlResponse(w, httptest.NewRequest("GET", "http://device:8080/confs/net", nil), "/net", nil)
+	if page := w.Body.String(); !strings.Contains(page, "csrfToken = \"\";") {
+		t.Errorf("page without a session has a CSRF token:\n%s", page)
+	}
+
+	script, err := ioutil.ReadFile("formgen.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(script), "withCredentials = true") {
+		t.Errorf("formgen.js asks for credentials without a session")
+	}
+}
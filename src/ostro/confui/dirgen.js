@@ -33,7 +33,7 @@ function generateEntries(table, resource, entries) {
 
     var rl = resource.split("/")
     var path = rl.slice(2, rl.length).join("/")
-    var urlBase = window.location.protocol + "//" + window.location.host + "/confs/" + path
+    var urlBase = window.location.protocol + "//" + window.location.host + getUIBase() + "/" + path
     
     for (var i in sortedEntries) {
         var entry = sortedEntries[i]
@@ -42,7 +42,7 @@ function generateEntries(table, resource, entries) {
 
         var label = document.createElement("td")
         label.className = "label"
-        label.appendChild(document.createTextNode(entry.desc))
+        label.appendChild(document.createTextNode(tr(entry.desc)))
         row.appendChild(label)
 
         var launcher = document.createElement("td")
@@ -83,7 +83,7 @@ window.onload = function() {
         ("title" in pageDef) &&
         ("entries" in pageDef))
     {
-        document.title = pageDef.title
+        document.title = tr(pageDef.title)
 
         generateToolBar(page, pageDef.resource)
         generateWorkArea(page, pageDef)
@@ -1,4 +1,5 @@
 dialogBox = "";
+resourceETags = {};
 
 function dottedIP4Pattern() {
         return "[0-9][0-9]{0,2}\.[0-9][0-9]{0,2}\.[0-9][0-9]{0,2}\.[0-9][0-9]{0,2}"
@@ -10,6 +11,7 @@ function updateForm(resource, name) {
     xmlhttp.onreadystatechange = function() {
         if (xmlhttp.readyState == 4) {
             if (xmlhttp.status == 200) {
+                resourceETags[resource] = xmlhttp.getResponseHeader("ETag")
                 parseValues(JSON.parse(xmlhttp.responseText), "");
             }
             delete xmlhttp
@@ -17,6 +19,7 @@ function updateForm(resource, name) {
     }
 
     xmlhttp.open("GET", getRestURL(resource), true);
+    xmlhttp.withCredentials = withSession();
     xmlhttp.send();
 }
 
@@ -26,8 +29,17 @@ function saveForm(resource, name) {
 
     xmlhttp.onreadystatechange = function() {
         if (xmlhttp.readyState == 4) {
-            var status = "values succesfully sent"
-            if (xmlhttp.status != 200) {
+            var status = tr("values succesfully sent")
+            if (xmlhttp.status == 200) {
+                resourceETags[resource] = xmlhttp.getResponseHeader("ETag")
+            }
+            else if (xmlhttp.status == 412) {
+                status = tr("values were changed meanwhile; reload the page to see them")
+            }
+            else if (xmlhttp.status == 401) {
+                status = tr("the session has expired; reload the page to log in again")
+            }
+            else {
                 if (xmlhttp.responseText != "") {
                     status = xmlhttp.responseText
                 }
@@ -35,7 +47,7 @@ function saveForm(resource, name) {
                     status = xmlhttp.statusText
                 }
                 else {
-                    status = "failed to send values"
+                    status = tr("failed to send values")
                 }
             }
             dialogBox.popup(status)
@@ -46,14 +58,31 @@ function saveForm(resource, name) {
     //console.log("values: " + values)
     
     xmlhttp.open("PUT", getRestURL(resource), true);
+    xmlhttp.withCredentials = withSession();
     xmlhttp.setRequestHeader("Content-Type", "application/json");
+    if (typeof csrfToken == "string" && csrfToken) {
+        xmlhttp.setRequestHeader("X-CSRF-Token", csrfToken);
+    }
     xmlhttp.setRequestHeader("Accept", "application/json; charset=utf-8");
+    if (resourceETags[resource]) {
+        xmlhttp.setRequestHeader("If-Match", resourceETags[resource]);
+    }
     xmlhttp.send(values);
 }
 
+// the session cookie is only sent with a login; without one the REST
+// server may answer any origin with '*', which rules credentials out
+function withSession() {
+    return typeof csrfToken == "string" && csrfToken != ""
+}
+
 function getRestURL(resource) {
-    var origin = window.location.origin.split(":")
-    return origin[0] + ":" + origin[1] + ":4984/confs" + resource;
+    var base = (typeof restBaseURL == "string") ? restBaseURL : ":4984/confs"
+
+    if (base.charAt(0) == ":") {
+        base = window.location.protocol + "//" + window.location.hostname + base
+    }
+    return base + resource;
 }
 
 function parseValues(values, prefix) {
@@ -205,7 +234,7 @@ function generateFields(table, idPrefix, namePrefix, depth, fields)
 
         var label = document.createElement("td")
         label.className = "label" + ((depth > 0) ? String(depth) : "")
-        label.appendChild(document.createTextNode(name))
+        label.appendChild(document.createTextNode(tr(name)))
         row.appendChild(label)
 
         var value = document.createElement("td")
@@ -257,7 +286,7 @@ function generateInput(parent, id, name, def) {
     input.id = id
     input.className = "input"
     input.name = name
-    input.title = def.desc
+    input.title = tr(def.desc)
 
     if ("defval" in def) {
         switch (def.type) {
@@ -281,14 +310,14 @@ function generateSelect(parent, id, name, def) {
     select.id = id
     select.className = "input"
     select.name = name
-    select.title = def.desc
+    select.title = tr(def.desc)
 
     for (optVal in def.options) {
         var optText = def.options[optVal]
         
         var option = document.createElement("option")
         option.value = optVal
-        option.appendChild(document.createTextNode(optText))
+        option.appendChild(document.createTextNode(tr(optText)))
         if (optVal == def.defval) { option.selected = true }
 
         select.appendChild(option)
@@ -344,19 +373,19 @@ function generateFormButtonBar(form, def) {
     var apply = document.createElement("button")
     apply.type = "submit"
     apply.className = "buttonbar"
-    apply.appendChild(document.createTextNode("Apply"))
+    apply.appendChild(document.createTextNode(tr("Apply")))
     buttons.appendChild(apply)
     
     var reload = document.createElement("button")
     reload.type = "button"
     reload.className = "buttonbar"
-    reload.appendChild(document.createTextNode("Reload"))
+    reload.appendChild(document.createTextNode(tr("Reload")))
     buttons.appendChild(reload)
     
     var reset = document.createElement("button")
     reset.type = "button"
     reset.className = "buttonbar"
-    reset.appendChild(document.createTextNode("Reset"))
+    reset.appendChild(document.createTextNode(tr("Reset")))
     buttons.appendChild(reset)
     
     actions(def.resource, def.name, form, reload, reset)
@@ -430,7 +459,7 @@ function generateMsgboxButtonBar(cover, dialog, msgbox, message, def) {
     var ok = document.createElement("button")
     ok.type = "button"
     ok.className = "buttonbar"
-    ok.appendChild(document.createTextNode("OK"))
+    ok.appendChild(document.createTextNode(tr("OK")))
     buttons.appendChild(ok)
     
     buttonBar.appendChild(buttons)
@@ -455,7 +484,7 @@ window.onload = function() {
         ("title" in pageDef) &&
         ("fields" in pageDef))
     {
-        document.title = pageDef.title
+        document.title = tr(pageDef.title)
 
         generateToolBar(page, pageDef.resource)
         generateWorkArea(page)
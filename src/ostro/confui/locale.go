@@ -0,0 +1,361 @@
+package confui
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+	"regexp"
+	"strconv"
+	"strings"
+	"net/http"
+	"io/ioutil"
+	"path/filepath"
+	"encoding/json"
+	"ostro/confs"
+)
+
+const (
+	// LocaleDir is the directory of the catalogs under the definition
+	// root, like /usr/share/confs/ui/locale/de.json.
+	LocaleDir = "locale"
+	LangCookie = "confs-lang"
+	LangParam = "lang"
+)
+
+// Catalog translates the texts of the default language, like "Apply" or
+// the titles and descriptions of the definitions. An empty translation
+// counts as missing.
+type Catalog map[string]string
+
+// UITexts are the texts of the pages themselves, to be translated along
+// with the definitions.
+var UITexts = []string{
+	"confs", "Apply", "Reload", "Reset", "OK", "logout", "Login", "user", "password",
+	"invalid user or password",
+	"values succesfully sent",
+	"values were changed meanwhile; reload the page to see them",
+	"the session has expired; reload the page to log in again",
+	"failed to send values",
+	"values saved",
+	"some values are invalid",
+	"failed to read the values: %v",
+	"failed to save the values: %v",
+	"'%s' is not one of the choices",
+	"a whole number is needed",
+	"the minimum is %s",
+	"the maximum is %s",
+	"the value does not have the expected format"}
+
+var (
+	defaultLanguage = "en"
+	langPattern = regexp.MustCompile(`^[A-Za-z]{1,8}(-[A-Za-z0-9]{1,8})*$`)
+
+	catalogLock sync.Mutex
+	catalogs = map[string]*cachedCatalog{}
+)
+
+type cachedCatalog struct {
+	modTime time.Time
+	size int64
+	catalog Catalog
+}
+
+// SetDefaultLanguage names the language of the definitions, which is used
+// when no catalog matches; "en" by default. A catalog of it may still
+// override texts.
+func SetDefaultLanguage(lang string) error {
+	if !langPattern.MatchString(lang) {
+		return fmt.Errorf("invalid language '%s'", lang)
+	}
+	defaultLanguage = lang
+	return nil
+}
+
+func ReadCatalog(path string) (Catalog, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := Catalog{}
+	if err := json.Unmarshal(content, &catalog); err != nil {
+		return nil, fmt.Errorf("invalid catalog '%s': %v", path, err)
+	}
+
+	return catalog, nil
+}
+
+// Languages lists the catalogs under a definition root.
+func Languages(root string) []string {
+	langs := []string{}
+
+	files, _ := filepath.Glob(filepath.Join(root, LocaleDir, "*.json"))
+	for _, file := range files {
+		if lang := strings.TrimSuffix(filepath.Base(file), ".json"); langPattern.MatchString(lang) {
+			langs = append(langs, lang)
+		}
+	}
+	sort.Strings(langs)
+
+	return langs
+}
+
+// catalog returns the catalog of a language of the UI, if there is one,
+// reading it again when it changed.
+func catalog(lang string) Catalog {
+	for _, l := range Languages(uiServer.prefix) {
+		if !strings.EqualFold(l, lang) {
+			continue
+		}
+
+		path := filepath.Join(uiServer.prefix, LocaleDir, l + ".json")
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil
+		}
+
+		catalogLock.Lock()
+		defer catalogLock.Unlock()
+
+		cached := catalogs[path]
+		if cached == nil || !cached.modTime.Equal(info.ModTime()) || cached.size != info.Size() {
+			c, err := ReadCatalog(path)
+			if err != nil {
+				log.Printf("ignoring catalog: %v\n", err)
+				return nil
+			}
+			cached = &cachedCatalog{modTime: info.ModTime(), size: info.Size(), catalog: c}
+			catalogs[path] = cached
+		}
+
+		return cached.catalog
+	}
+
+	return nil
+}
+
+// labels are the texts of a page in the language of the request.
+type labels struct {
+	Lang string
+	texts map[string]string
+}
+
+// newLabels merges the catalogs of the language, its base language, eg.
+// de for de-AT, and the default language, in this order of preference.
+func newLabels(lang string) *labels {
+	me := &labels{Lang: lang, texts: map[string]string{}}
+
+	for _, l := range fallbackLanguages(lang) {
+		for text, translation := range catalog(l) {
+			if _, found := me.texts[text]; !found && translation != "" {
+				me.texts[text] = translation
+			}
+		}
+	}
+
+	return me
+}
+
+func fallbackLanguages(lang string) []string {
+	langs := []string{}
+	seen := map[string]bool{}
+
+	for _, l := range []string{lang, baseLanguage(lang), defaultLanguage, baseLanguage(defaultLanguage)} {
+		if key := strings.ToLower(l); !seen[key] {
+			seen[key] = true
+			langs = append(langs, l)
+		}
+	}
+
+	return langs
+}
+
+func baseLanguage(lang string) string {
+	return strings.SplitN(lang, "-", 2)[0]
+}
+
+// Tr translates a text; missing ones stay in the default language.
+func (me *labels) Tr(text string) string {
+	if me != nil {
+		if translation, found := me.texts[text]; found {
+			return translation
+		}
+	}
+	return text
+}
+
+func (me *labels) Errorf(format string, args ...interface{}) error {
+	return fmt.Errorf(me.Tr(format), args...)
+}
+
+// requestLanguage picks the language of the page: the one asked for by
+// the lang parameter, which is remembered in a cookie, the remembered one
+// or the most preferred of Accept-Language having a catalog.
+func requestLanguage(w http.ResponseWriter, r *http.Request) string {
+	if lang := r.URL.Query().Get(LangParam); langPattern.MatchString(lang) {
+		http.SetCookie(w, &http.Cookie{
+			Name: LangCookie,
+			Value: lang,
+			Path: uiServer.pattern + "/",
+			MaxAge: 365 * 24 * 3600,
+			SameSite: http.SameSiteLaxMode})
+		return lang
+	}
+
+	if cookie, err := r.Cookie(LangCookie); err == nil && langPattern.MatchString(cookie.Value) {
+		return cookie.Value
+	}
+
+	available := Languages(uiServer.prefix)
+	for _, lang := range acceptedLanguages(r.Header.Get("Accept-Language")) {
+		for _, l := range available {
+			if strings.EqualFold(l, lang) || strings.EqualFold(l, baseLanguage(lang)) {
+				return lang
+			}
+		}
+		if strings.EqualFold(baseLanguage(lang), baseLanguage(defaultLanguage)) {
+			return lang
+		}
+	}
+
+	return defaultLanguage
+}
+
+// acceptedLanguages orders the languages of an Accept-Language header by
+// their quality.
+func acceptedLanguages(header string) []string {
+	type accepted struct {
+		lang string
+		q float64
+	}
+	list := []accepted{}
+
+	for _, item := range strings.Split(header, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ";")
+		lang := strings.TrimSpace(parts[0])
+		if !langPattern.MatchString(lang) {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range parts[1:] {
+			if kv := strings.SplitN(strings.TrimSpace(param), "=", 2); len(kv) == 2 && kv[0] == "q" {
+				if v, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			list = append(list, accepted{lang, q})
+		}
+	}
+
+	sort.SliceStable(list, func(i, j int) bool { return list[i].q > list[j].q })
+
+	langs := make([]string, len(list))
+	for i, a := range list {
+		langs[i] = a.lang
+	}
+
+	return langs
+}
+
+// DefinitionTexts collects the texts to translate of a definition: its
+// title, the names, descriptions and choices of the fields and the
+// descriptions of the entries.
+func DefinitionTexts(def confs.Definition) []string {
+	texts := []string{}
+
+	add := func(v interface{}) {
+		if text, ok := v.(string); ok && text != "" {
+			texts = append(texts, text)
+		}
+	}
+
+	var addFields func(fields map[string]interface{})
+	addField := func(def map[string]interface{}) {
+		add(def["desc"])
+		if options, ok := def["options"].(map[string]interface{}); ok {
+			for _, opt := range confs.Keys(options) {
+				add(options[opt])
+			}
+		}
+	}
+	addFields = func(fields map[string]interface{}) {
+		for _, name := range confs.Keys(fields) {
+			def, ok := fields[name].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			add(name)
+			addField(def)
+			if value, ok := def["value"].(map[string]interface{}); ok {
+				addField(value)
+			}
+			if sub, ok := def["fields"].(map[string]interface{}); ok {
+				addFields(sub)
+			}
+		}
+	}
+
+	add(def["title"])
+	if fields, ok := def["fields"].(map[string]interface{}); ok {
+		addFields(fields)
+	}
+	if entries, ok := def["entries"].([]interface{}); ok {
+		for _, e := range entries {
+			if entry, ok := e.(map[string]interface{}); ok {
+				add(entry["desc"])
+			}
+		}
+	}
+
+	return texts
+}
+
+// Texts collects the texts to translate of the pages and of all the
+// definitions under root, sorted. Definitions that fail to parse are
+// reported and skipped.
+func Texts(root string) ([]string, error) {
+	seen := map[string]bool{}
+	for _, text := range UITexts {
+		seen[text] = true
+	}
+
+	root = strings.TrimRight(root, "/")
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && (path == root + "/infra" || path == filepath.Join(root, LocaleDir)) {
+			return filepath.SkipDir
+		}
+		if !info.Mode().IsRegular() || !strings.HasSuffix(path, ".js") {
+			return nil
+		}
+
+		def, err := confs.ReadDefinition(path)
+		if err != nil {
+			log.Printf("skipping '%s': %v\n", path, err)
+			return nil
+		}
+		for _, text := range DefinitionTexts(def) {
+			seen[text] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	texts := make([]string, 0, len(seen))
+	for text := range seen {
+		texts = append(texts, text)
+	}
+	sort.Strings(texts)
+
+	return texts, nil
+}
@@ -0,0 +1,527 @@
+package confui
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"ostro/confs"
+	"ostro/session"
+)
+
+// Store gives the pages rendered on the server the values of the nodes,
+// like /network/wifi. The REST handler is one.
+type Store interface {
+	ReadNode(node string, s *session.Session) (map[string]interface{}, error)
+	WriteNode(node string, values map[string]interface{}, s *session.Session) error
+}
+
+var (
+	nodeStore Store = nil
+)
+
+// SetStore makes the pages render their forms and directory listings on
+// the server too, for browsers without JavaScript, and accept the posted
+// forms. The store may come after the handler, eg. when the REST handler
+// falls back to the UI.
+func SetStore(store Store) {
+	nodeStore = store
+}
+
+const renderTemplates = `
+{{define "content"}}<div class="page">
+  <div class="toolbar"><div class="navigator"><span class="navigator">
+    {{range $i, $c := .Crumbs}}{{if $i}} / {{end}}<a href="{{$c.URL}}">{{$c.Name}}</a>{{end}}
+  </span></div>
+  {{if .CSRFToken}}<form class="logout" method="post" action="{{.Base}}/logout">
+    <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+    <button type="submit" class="navigator">{{.Labels.Tr "logout"}}</button>
+  </form>{{end}}</div>
+  <div class="workarea">
+  {{if .Message}}<p class="{{if .Failed}}message{{else}}notice{{end}}">{{.Message}}</p>{{end}}
+  {{with .Form}}<form class="entry" method="post" action="{{.Action}}">
+    <div class="container">
+      <table class="entry">
+      {{range .Rows}}<tr>
+        <td class="label{{if .Depth}}{{.Depth}}{{end}}">{{.Label}}</td>
+        <td class="value">{{if eq .Type "select"}}<select class="input" id="{{.ID}}" name="{{.Name}}" title="{{.Desc}}">
+          {{range .Options}}<option value="{{.Value}}"{{if .Selected}} selected{{end}}>{{.Text}}</option>
+          {{end}}</select>{{else if eq .Type "checkbox"}}<input type="hidden" name="{{.Name}}" value="false"><input class="input" type="checkbox" id="{{.ID}}" name="{{.Name}}" value="true" title="{{.Desc}}"{{if .Checked}} checked{{end}}>{{else if .Type}}<input class="input" type="{{.Type}}" id="{{.ID}}" name="{{.Name}}" value="{{.Value}}" title="{{.Desc}}"{{if .Pattern}} pattern="{{.Pattern}}"{{end}}{{if .Min}} min="{{.Min}}"{{end}}{{if .Max}} max="{{.Max}}"{{end}}{{if .Size}} size="{{.Size}}"{{end}}>{{end}}
+          {{if .Error}}<span class="error">{{.Error}}</span>{{end}}</td>
+      </tr>
+      {{end}}</table>
+      {{if $.CSRFToken}}<input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">{{end}}
+    </div>
+    <div class="buttonbar"><div class="buttons"><button type="submit" class="buttonbar">{{$.Labels.Tr "Apply"}}</button></div></div>
+  </form>{{end}}
+  {{if .Entries}}<div class="dirlist"><table class="dirlist">
+    {{range .Entries}}<tr><td class="label"><a href="{{.URL}}">{{.Desc}}</a></td></tr>
+    {{end}}</table></div>{{end}}
+  </div>
+</div>{{end}}
+{{define "page"}}<!DOCTYPE html>
+<html lang="{{.Labels.Lang}}">
+  <head>
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>{{.Title}}</title>
+    <link rel="stylesheet" type="text/css" href="{{.CSS}}">
+  </head>
+  <body>
+    {{template "content" .}}
+  </body>
+</html>
+{{end}}`
+
+var pageTemplates = template.Must(template.New("confui").Parse(renderTemplates))
+
+type pageView struct {
+	Title string
+	Base string
+	CSS string
+	Crumbs []linkView
+	Message string
+	Failed bool
+	Form *formView
+	Entries []entryView
+	CSRFToken string
+	Labels *labels
+}
+
+type linkView struct {
+	Name string
+	URL string
+}
+
+type entryView struct {
+	Desc string
+	URL string
+}
+
+type formView struct {
+	Action string
+	Rows []fieldView
+}
+
+type fieldView struct {
+	ID string
+	Name string
+	Label string
+	Desc string
+	Type string
+	Depth int
+	Value string
+	Checked bool
+	Options []optionView
+	Pattern string
+	Min string
+	Max string
+	Size string
+	Error string
+}
+
+type optionView struct {
+	Value string
+	Text string
+	Selected bool
+}
+
+// valueFunc returns the value to show for the dotted name of a field.
+type valueFunc func(name string) (interface{}, bool)
+
+// renderPage renders the page of a node the way formgen.js or dirgen.js
+// would build it, with the current values of the store filled in. The
+// node is "" for the root.
+func renderPage(node string, def confs.Definition, s *session.Session, l *labels) *pageView {
+	page := newPageView(node, def, s, l)
+
+	if entries, ok := def["entries"].([]interface{}); ok {
+		page.Entries = entryViews(node, entries, l)
+		return page
+	}
+
+	fields, _ := def["fields"].(map[string]interface{})
+
+	values, err := nodeStore.ReadNode(node, s)
+	if err != nil {
+		log.Printf("     reading values of '%s' failed: %v\n", node, err)
+		page.Failed = true
+		page.Message = l.Errorf("failed to read the values: %v", err).Error()
+		return page
+	}
+
+	page.Form = &formView{
+		Action: uiServer.pattern + node,
+		Rows: fieldViews(fields, defName(def), "", 0, storedValues(values), nil, l)}
+
+	return page
+}
+
+func newPageView(node string, def confs.Definition, s *session.Session, l *labels) *pageView {
+	title, _ := def["title"].(string)
+
+	page := &pageView{
+		Title: l.Tr(title),
+		Base: uiServer.pattern,
+		CSS: assetURL("/infra/page.css"),
+		Crumbs: []linkView{{Name: l.Tr("confs"), URL: uiServer.pattern + "/"}},
+		Labels: l}
+
+	path := ""
+	for _, name := range strings.Split(strings.Trim(node, "/"), "/") {
+		if name != "" {
+			path += "/" + name
+			page.Crumbs = append(page.Crumbs, linkView{Name: name, URL: uiServer.pattern + path})
+		}
+	}
+
+	if s != nil {
+		page.CSRFToken = s.CSRFToken
+	}
+
+	return page
+}
+
+func defName(def confs.Definition) string {
+	name, _ := def["name"].(string)
+	return name
+}
+
+func entryViews(node string, entries []interface{}, l *labels) []entryView {
+	views := []entryView{}
+
+	for _, e := range entries {
+		entry, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := entry["name"].(string)
+		desc, _ := entry["desc"].(string)
+		if name == "" {
+			continue
+		}
+		if desc == "" {
+			desc = name
+		}
+		views = append(views, entryView{Desc: l.Tr(desc), URL: uiServer.pattern + node + "/" + url.PathEscape(name)})
+	}
+
+	sort.Slice(views, func(i, j int) bool { return views[i].URL < views[j].URL })
+
+	return views
+}
+
+// fieldViews flattens the fields into table rows, the sections being
+// followed by their fields one level deeper.
+func fieldViews(fields map[string]interface{}, idPrefix, namePrefix string, depth int, value valueFunc, errs map[string]string, l *labels) []fieldView {
+	rows := []fieldView{}
+
+	for _, key := range confs.Keys(fields) {
+		def, ok := fields[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		row := fieldView{ID: idPrefix + key, Name: namePrefix + key, Label: l.Tr(key), Depth: depth}
+		desc, _ := def["desc"].(string)
+		row.Desc = l.Tr(desc)
+		row.Error = errs[row.Name]
+
+		if typ, _ := def["type"].(string); typ == "section" {
+			sub, hasFields := def["fields"].(map[string]interface{})
+			if vdef, ok := def["value"].(map[string]interface{}); ok && vdef["type"] != "section" {
+				fillField(&row, vdef, value, l)
+				if hasFields && row.Type == "select" {
+					// '-' takes the fields below instead of a value
+					v, _ := value(row.Name)
+					row.Options = append([]optionView{{Value: "-", Text: "-", Selected: v == "-"}}, row.Options...)
+				}
+			}
+			rows = append(rows, row)
+			if hasFields {
+				rows = append(rows, fieldViews(sub, row.ID, row.Name + ".", depth + 1, value, errs, l)...)
+			}
+			continue
+		}
+
+		fillField(&row, def, value, l)
+		rows = append(rows, row)
+	}
+
+	return rows
+}
+
+func fillField(row *fieldView, def map[string]interface{}, value valueFunc, l *labels) {
+	row.Type, _ = def["type"].(string)
+	row.Pattern, _ = def["pattern"].(string)
+	row.Min = attrString(def["min"])
+	row.Max = attrString(def["max"])
+	row.Size = attrString(def["size"])
+
+	v, found := value(row.Name)
+	if !found {
+		v = def["defval"]
+	}
+
+	switch row.Type {
+	case "checkbox":
+		row.Checked = truthy(v)
+	case "select":
+		options, _ := def["options"].(map[string]interface{})
+		selected := attrString(v)
+		for _, opt := range confs.Keys(options) {
+			row.Options = append(row.Options, optionView{Value: opt, Text: l.Tr(attrString(options[opt])), Selected: opt == selected})
+		}
+	case "password":
+		// secrets are not sent back; an empty field keeps them
+	case "number":
+		if row.Pattern == "" {
+			// a sign only if the minimum allows negative numbers
+			if min, ok := def["min"].(float64); ok && min >= 0 {
+				row.Pattern = "[0-9]+"
+			} else {
+				row.Pattern = "-?[0-9]+"
+			}
+		}
+		fallthrough
+	default:
+		row.Value = attrString(v)
+	}
+}
+
+// storedValues looks up the fields in the values of a node; a section
+// having fields as its value reads as '-', like in formgen.js.
+func storedValues(values map[string]interface{}) valueFunc {
+	return func(name string) (interface{}, bool) {
+		var v interface{} = values
+		for _, key := range strings.Split(name, ".") {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if v, ok = m[key]; !ok {
+				return nil, false
+			}
+		}
+		if _, ok := v.(map[string]interface{}); ok {
+			return "-", true
+		}
+		return v, true
+	}
+}
+
+// postedValues shows the values of a rejected form as they were entered.
+func postedValues(form url.Values) valueFunc {
+	return func(name string) (interface{}, bool) {
+		values, ok := form[name]
+		if !ok || len(values) == 0 {
+			return nil, false
+		}
+		return values[len(values)-1], true
+	}
+}
+
+// readForm converts and validates the posted fields, following the
+// rules of the definition. Sections with a value other than '-' take
+// that value instead of their fields.
+func readForm(fields map[string]interface{}, namePrefix string, form url.Values, values map[string]interface{}, errs map[string]string, l *labels) {
+	for _, key := range confs.Keys(fields) {
+		def, ok := fields[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name := namePrefix + key
+
+		if typ, _ := def["type"].(string); typ == "section" {
+			if vdef, ok := def["value"].(map[string]interface{}); ok && vdef["type"] != "section" && form.Get(name) != "-" {
+				if v, set, err := readField(vdef, name, form, l); err != nil {
+					errs[name] = err.Error()
+					continue
+				} else if set {
+					values[key] = v
+					continue
+				}
+			}
+			if sub, ok := def["fields"].(map[string]interface{}); ok {
+				section := map[string]interface{}{}
+				readForm(sub, name + ".", form, section, errs, l)
+				if len(section) > 0 {
+					values[key] = section
+				}
+			}
+			continue
+		}
+
+		if v, set, err := readField(def, name, form, l); err != nil {
+			errs[name] = err.Error()
+		} else if set {
+			values[key] = v
+		}
+	}
+}
+
+// readField returns the value of a posted field, if it is to be set.
+func readField(def map[string]interface{}, name string, form url.Values, l *labels) (interface{}, bool, error) {
+	posted, ok := form[name]
+	if !ok || len(posted) == 0 {
+		return nil, false, nil
+	}
+	// a checked box comes after its hidden 'false'
+	text := posted[len(posted)-1]
+
+	typ, _ := def["type"].(string)
+
+	switch typ {
+	case "checkbox":
+		return text == "true" || text == "on", true, nil
+	case "select":
+		options, _ := def["options"].(map[string]interface{})
+		for _, opt := range confs.Keys(options) {
+			if opt == text {
+				return text, true, nil
+			}
+		}
+		return nil, false, l.Errorf("'%s' is not one of the choices", text)
+	case "number":
+		n, err := strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+		if err != nil {
+			return nil, false, l.Errorf("a whole number is needed")
+		}
+		if min, ok := def["min"].(float64); ok && float64(n) < min {
+			return nil, false, l.Errorf("the minimum is %s", attrString(min))
+		}
+		if max, ok := def["max"].(float64); ok && float64(n) > max {
+			return nil, false, l.Errorf("the maximum is %s", attrString(max))
+		}
+		return float64(n), true, nil
+	case "password":
+		if text == "" {
+			return nil, false, nil
+		}
+	}
+
+	if pattern, ok := def["pattern"].(string); ok && pattern != "" && text != "" {
+		// the pattern of an input matches the whole value
+		if re, err := regexp.Compile("^(?:" + pattern + ")$"); err != nil {
+			log.Printf("     ignoring pattern of '%s': %v\n", name, err)
+		} else if !re.MatchString(text) {
+			return nil, false, l.Errorf("the value does not have the expected format")
+		}
+	}
+
+	return text, true, nil
+}
+
+// postForm validates and stores a form posted by a browser without
+// JavaScript. A rejected form is shown again with the errors, an
+// accepted one redirects to the page.
+func postForm(w http.ResponseWriter, r *http.Request, node string, def confs.Definition, s *session.Session, l *labels) {
+	if s != nil {
+		if err := session.CheckCSRF(r, s); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, fmt.Sprintf("malformed form: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	fields, ok := def["fields"].(map[string]interface{})
+	if !ok {
+		http.Error(w, "the page has no form", http.StatusMethodNotAllowed)
+		return
+	}
+
+	values := map[string]interface{}{}
+	errs := map[string]string{}
+
+	readForm(fields, "", r.PostForm, values, errs, l)
+
+	page := newPageView(node, def, s, l)
+	status := http.StatusBadRequest
+
+	if len(errs) > 0 {
+		page.Message = l.Tr("some values are invalid")
+	} else if err := nodeStore.WriteNode(node, values, s); err != nil {
+		log.Printf("     writing values of '%s' failed: %v\n", node, err)
+		page.Message = l.Errorf("failed to save the values: %v", err).Error()
+		status = writeErrorStatus(err)
+	} else {
+		http.Redirect(w, r, uiServer.pattern + node + "?saved=1", http.StatusSeeOther)
+		return
+	}
+
+	page.Failed = true
+	page.Form = &formView{
+		Action: uiServer.pattern + node,
+		Rows: fieldViews(fields, defName(def), "", 0, postedValues(r.PostForm), errs, l)}
+
+	writeRenderedPage(w, page, status)
+}
+
+func writeErrorStatus(err error) int {
+	switch {
+	case confs.IsPathError(err):
+		return http.StatusBadRequest
+	case confs.IsConflictError(err):
+		return http.StatusConflict
+	case err == session.AccessError:
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+func writeRenderedPage(w http.ResponseWriter, page *pageView, status int) {
+	var buf bytes.Buffer
+	if err := pageTemplates.ExecuteTemplate(&buf, "page", page); err != nil {
+		http.Error(w, fmt.Sprintf("failed to render the page: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// renderContent returns the body of a page for its noscript element.
+func renderContent(page *pageView) (string, error) {
+	var buf bytes.Buffer
+	if err := pageTemplates.ExecuteTemplate(&buf, "content", page); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func attrString(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(t)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case bool:
+		return t
+	case string:
+		return t == "true" || t == "on"
+	case float64:
+		return t != 0
+	}
+	return false
+}
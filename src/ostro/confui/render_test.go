@@ -0,0 +1,190 @@
+package confui
+
+import (
+	"regexp"
+	"reflect"
+	"strings"
+	"testing"
+	"net/url"
+	"net/http"
+	"net/http/httptest"
+	"ostro/confs"
+	"ostro/session"
+)
+
+func TestReadFieldNumber(t *testing.T) {
+	def := map[string]interface{}{"type": "number", "min": float64(1), "max": float64(13)}
+
+	for _, c := range []struct{
+		text string
+		value interface{}
+		valid bool
+	}{
+		{"5", float64(5), true},
+		{" 13 ", float64(13), true},
+		{"0", nil, false},
+		{"14", nil, false},
+		{"-3", nil, false},
+		{"2.5", nil, false},
+		{"x", nil, false},
+	} {
+		v, set, err := readField(def, "chan", url.Values{"chan": {c.text}}, nil)
+		if c.valid != (err == nil) || set != c.valid || v != c.value {
+			t.Errorf("'%s': got %v, %v, %v", c.text, v, set, err)
+		}
+	}
+
+	if _, set, err := readField(def, "chan", url.Values{}, nil); set || err != nil {
+		t.Errorf("missing field: got %v, %v", set, err)
+	}
+}
+
+func TestNumberPattern(t *testing.T) {
+	for _, c := range []struct{
+		def map[string]interface{}
+		text string
+		valid bool
+	}{
+		{map[string]interface{}{"type": "number", "min": float64(-10)}, "-5", true},
+		{map[string]interface{}{"type": "number"}, "-5", true},
+		{map[string]interface{}{"type": "number", "min": float64(0)}, "-5", false},
+		{map[string]interface{}{"type": "number", "min": float64(1)}, "5", true},
+	} {
+		row := &fieldView{Name: "n"}
+		fillField(row, c.def, storedValues(nil), nil)
+
+		re := regexp.MustCompile("^(?:" + row.Pattern + ")$")
+		if re.MatchString(c.text) != c.valid {
+			t.Errorf("pattern '%s' for min %v: '%s' valid is not %v", row.Pattern, c.def["min"], c.text, c.valid)
+		}
+		if _, _, err := readField(c.def, "n", url.Values{"n": {c.text}}, nil); (err == nil) != c.valid {
+			t.Errorf("min %v: '%s' read with %v", c.def["min"], c.text, err)
+		}
+	}
+}
+
+func testSection() map[string]interface{} {
+	return map[string]interface{}{
+		"ipv4": map[string]interface{}{
+			"type": "section",
+			"value": map[string]interface{}{
+				"type": "select",
+				"options": map[string]interface{}{"dhcp": "DHCP", "manual": "Manual"}},
+			"fields": map[string]interface{}{
+				"address": map[string]interface{}{"type": "text"},
+				"prefix": map[string]interface{}{"type": "text", "pattern": "[0-9]+"}}}}
+}
+
+func TestReadFormSection(t *testing.T) {
+	for _, c := range []struct{
+		form url.Values
+		values map[string]interface{}
+		errs int
+	}{
+		{url.Values{"ipv4": {"dhcp"}, "ipv4.address": {"10.0.0.2"}},
+			map[string]interface{}{"ipv4": "dhcp"}, 0},
+		{url.Values{"ipv4": {"-"}, "ipv4.address": {"10.0.0.2"}, "ipv4.prefix": {"24"}},
+			map[string]interface{}{"ipv4": map[string]interface{}{"address": "10.0.0.2", "prefix": "24"}}, 0},
+		{url.Values{"ipv4": {"-"}, "ipv4.prefix": {"x"}},
+			map[string]interface{}{}, 1},
+		{url.Values{"ipv4": {"static"}},
+			map[string]interface{}{}, 1},
+	} {
+		values := map[string]interface{}{}
+		errs := map[string]string{}
+		readForm(testSection(), "", c.form, values, errs, nil)
+
+		if !reflect.DeepEqual(values, c.values) || len(errs) != c.errs {
+			t.Errorf("%v: got %v, errors %v", c.form, values, errs)
+		}
+	}
+}
+
+func TestSectionRendersDash(t *testing.T) {
+	stored := map[string]interface{}{"ipv4": map[string]interface{}{"address": "10.0.0.2"}}
+
+	rows := fieldViews(testSection(), "net", "", 0, storedValues(stored), nil, nil)
+	if len(rows) == 0 || rows[0].Name != "ipv4" {
+		t.Fatalf("no section row in %v", rows)
+	}
+
+	selected := ""
+	for _, opt := range rows[0].Options {
+		if opt.Selected {
+			selected = opt.Value
+		}
+	}
+	if rows[0].Options[0].Value != "-" || selected != "-" {
+		t.Errorf("section with fields does not select '-': %v", rows[0].Options)
+	}
+}
+
+func TestPasswordKeptEmpty(t *testing.T) {
+	def := map[string]interface{}{"type": "password"}
+
+	row := &fieldView{Name: "psk"}
+	fillField(row, def, storedValues(map[string]interface{}{"psk": "secret"}), nil)
+	if row.Value != "" {
+		t.Errorf("password sent back as '%s'", row.Value)
+	}
+
+	if _, set, err := readField(def, "psk", url.Values{"psk": {""}}, nil); set || err != nil {
+		t.Errorf("empty password would be stored: %v, %v", set, err)
+	}
+	if v, set, _ := readField(def, "psk", url.Values{"psk": {"new"}}, nil); !set || v != "new" {
+		t.Errorf("new password not stored: %v, %v", v, set)
+	}
+}
+
+type fakeStore struct {
+	node string
+	values map[string]interface{}
+}
+
+func (me *fakeStore) ReadNode(node string, s *session.Session) (map[string]interface{}, error) {
+	return map[string]interface{}{}, nil
+}
+
+func (me *fakeStore) WriteNode(node string, values map[string]interface{}, s *session.Session) error {
+	me.node = node
+	me.values = values
+	return nil
+}
+
+func TestPostFormCSRF(t *testing.T) {
+	store := &fakeStore{}
+	savedServer, savedStore := uiServer, nodeStore
+	uiServer = &Server{pattern: "/confs", prefix: t.TempDir()}
+	nodeStore = store
+	defer func() {
+		uiServer, nodeStore = savedServer, savedStore
+	}()
+
+	def := confs.Definition{"fields": map[string]interface{}{"ssid": map[string]interface{}{"type": "text"}}}
+	s := &session.Session{ID: "id", User: "alice", CSRFToken: "token"}
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/confs/net/wifi", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		postForm(w, r, "/net/wifi", def, s, nil)
+		return w
+	}
+
+	for _, token := range []string{"", "forged"} {
+		if w := post(url.Values{"ssid": {"home"}, session.CSRFField: {token}}); w.Code != http.StatusForbidden {
+			t.Errorf("token '%s': got %d", token, w.Code)
+		}
+	}
+	if store.values != nil {
+		t.Errorf("rejected form was written: %v", store.values)
+	}
+
+	w := post(url.Values{"ssid": {"home"}, session.CSRFField: {"token"}})
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/confs/net/wifi?saved=1" {
+		t.Errorf("valid form: got %d to '%s'", w.Code, w.Header().Get("Location"))
+	}
+	if store.node != "/net/wifi" || store.values["ssid"] != "home" {
+		t.Errorf("valid form wrote %v to '%s'", store.values, store.node)
+	}
+}
@@ -1,3 +1,14 @@
+function getUIBase() {
+    return (typeof uiBaseURL == "string") ? uiBaseURL : "/confs"
+}
+
+function tr(text) {
+    if (typeof uiLabels == "object" && uiLabels && uiLabels.hasOwnProperty(text)) {
+        return uiLabels[text]
+    }
+    return text
+}
+
 function generateToolBar(parent, resource) {
     var actions = function(backUrl, back, go) {
         this.backUrl = backUrl
@@ -15,7 +26,7 @@ function generateToolBar(parent, resource) {
         }
     }
     
-    var urlBase = window.location.protocol + "//" + window.location.host + "/confs"
+    var urlBase = window.location.protocol + "//" + window.location.host + getUIBase()
 
     var bar = document.createElement("div")
     bar.className = "toolbar"
@@ -34,7 +45,7 @@ function generateToolBar(parent, resource) {
     var rl = resource.split("/")
     var go = []
 
-    var root = generateNavigatorButton(naviSpan, "confs", "/ ", urlBase)
+    var root = generateNavigatorButton(naviSpan, tr("confs"), "/ ", urlBase)
     go.push(root)
     if (rl.length < 3) {
         root.disabled = true
@@ -53,10 +64,14 @@ function generateToolBar(parent, resource) {
         naviSpan.appendChild(butt)
     }
     
-    actions("/confs/" + rl.slice(2,rl.length-1).join("/"), back,  go)
+    actions(getUIBase() + "/" + rl.slice(2,rl.length-1).join("/"), back,  go)
     
     naviDiv.appendChild(naviSpan)
     bar.appendChild(naviDiv)
+
+    if (typeof csrfToken == "string" && csrfToken) {
+        generateLogoutButton(bar)
+    }
     
     parent.appendChild(bar)
 }
@@ -74,3 +89,26 @@ function generateNavigatorButton(parent, name, sep, url) {
 
     return butt
 }
+
+function generateLogoutButton(parent) {
+    var form = document.createElement("form")
+    form.className = "logout"
+    form.method = "post"
+    form.action = getUIBase() + "/logout"
+
+    var token = document.createElement("input")
+    token.type = "hidden"
+    token.name = "csrf_token"
+    token.value = csrfToken
+    form.appendChild(token)
+
+    var butt = document.createElement("button")
+    butt.type = "submit"
+    butt.className = "navigator"
+    butt.appendChild(document.createTextNode(tr("logout")))
+    form.appendChild(butt)
+
+    parent.appendChild(form)
+
+    return form
+}
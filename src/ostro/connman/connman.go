@@ -6,8 +6,13 @@ import (
 	"github.com/godbus/dbus"
 )
 
+// Bus gives the connman objects the calls go to. A *dbus.Conn is one.
+type Bus interface {
+	Object(dest string, path dbus.ObjectPath) dbus.BusObject
+}
+
 type Server struct {
-	conn *dbus.Conn
+	conn Bus
 	address string
 }
 
@@ -59,3 +64,12 @@ func NewServer() (*Server, error) {
 	return srv, nil
 }
 
+
+// NewServerOnConn uses the given connection, eg. one to a private bus or
+// a fake, instead of the system bus. Unlike NewServer, it has no shared
+// state.
+func NewServerOnConn(conn Bus) *Server {
+	return &Server{
+		conn: conn,
+		address: "net.connman"}
+}
@@ -31,6 +31,8 @@ type servList struct {
 	Name string
 	Id string
 	State string
+	Strength uint8
+	IPv4 ipv4
 }
 
 type Manager struct {
@@ -0,0 +1,87 @@
+package connman
+
+import (
+	"github.com/godbus/dbus"
+)
+
+type TechnologyStatus struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	Powered bool `json:"powered"`
+	Connected bool `json:"connected"`
+	Tethering bool `json:"tethering"`
+}
+
+type AddressStatus struct {
+	Method string `json:"method,omitempty"`
+	Address string `json:"address,omitempty"`
+	Netmask string `json:"netmask,omitempty"`
+	Gateway string `json:"gateway,omitempty"`
+}
+
+type ServiceStatus struct {
+	Id string `json:"id"`
+	Type string `json:"type"`
+	Name string `json:"name"`
+	State string `json:"state"`
+	Strength uint8 `json:"strength,omitempty"`
+	IPv4 AddressStatus `json:"ipv4"`
+}
+
+type NetworkStatus struct {
+	State string `json:"state"`
+	OfflineMode bool `json:"offlineMode"`
+	Technologies []TechnologyStatus `json:"technologies"`
+	Services []ServiceStatus `json:"services"`
+}
+
+// GetNetworkStatus reads the live state from connman. It neither scans
+// nor touches the objects cached by NewManager and friends, so it can be
+// called any time.
+func (srv *Server) GetNetworkStatus() (*NetworkStatus, error) {
+	m := &Manager{object: srv.conn.Object(srv.address, dbus.ObjectPath("/"))}
+
+	if err := m.GetProperties(); err != nil {
+		return nil, err
+	}
+	if err := m.GetTechnologies(); err != nil {
+		return nil, err
+	}
+	if err := m.GetServices(); err != nil {
+		return nil, err
+	}
+
+	status := &NetworkStatus{
+		State: m.State,
+		OfflineMode: m.OfflineMode,
+		Technologies: []TechnologyStatus{},
+		Services: []ServiceStatus{}}
+
+	for _, tl := range m.Technologies {
+		path := dbus.ObjectPath("/net/connman/technology/" + tl.Type)
+		t := &Technology{object: srv.conn.Object(srv.address, path), Type: tl.Type}
+
+		if err := t.GetProperties(); err != nil {
+			return nil, err
+		}
+
+		status.Technologies = append(status.Technologies, TechnologyStatus{
+			Type: t.Type,
+			Name: t.Name,
+			Powered: t.Powered,
+			Connected: t.Connected,
+			Tethering: t.Tethering})
+	}
+
+	for _, s := range m.Services {
+		status.Services = append(status.Services, ServiceStatus{
+			Id: s.Id,
+			Type: s.Type,
+			Name: s.Name,
+			State: s.State,
+			Strength: s.Strength,
+			IPv4: AddressStatus(s.IPv4)})
+	}
+
+	return status, nil
+}
@@ -0,0 +1,133 @@
+package connman
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"github.com/godbus/dbus"
+)
+
+// fakeBus answers the method calls of connman objects with bodies in the
+// form the D-Bus decoder gives them.
+type fakeBus struct {
+	replies map[string][]interface{}	// by "<path> <method>"
+}
+
+type fakeObject struct {
+	bus *fakeBus
+	dest string
+	path dbus.ObjectPath
+}
+
+func (me *fakeBus) Object(dest string, path dbus.ObjectPath) dbus.BusObject {
+	return &fakeObject{bus: me, dest: dest, path: path}
+}
+
+func (me *fakeObject) Call(method string, flags dbus.Flags, args ...interface{}) *dbus.Call {
+	call := &dbus.Call{Destination: me.dest, Path: me.path, Method: method, Args: args}
+
+	if body, ok := me.bus.replies[string(me.path) + " " + method]; ok && me.dest == "net.connman" {
+		call.Body = body
+	} else {
+		call.Err = fmt.Errorf("no method '%s' on '%s'", method, me.path)
+	}
+
+	return call
+}
+
+func (me *fakeObject) Go(method string, flags dbus.Flags, ch chan *dbus.Call, args ...interface{}) *dbus.Call {
+	call := me.Call(method, flags, args...)
+	if ch != nil {
+		ch <- call
+	}
+	return call
+}
+
+func (me *fakeObject) GetProperty(p string) (dbus.Variant, error) {
+	return dbus.Variant{}, fmt.Errorf("no property '%s' on '%s'", p, me.path)
+}
+
+func (me *fakeObject) Destination() string {
+	return me.dest
+}
+
+func (me *fakeObject) Path() dbus.ObjectPath {
+	return me.path
+}
+
+func props(values map[string]interface{}) map[string]dbus.Variant {
+	dict := map[string]dbus.Variant{}
+	for name, v := range values {
+		dict[name] = dbus.MakeVariant(v)
+	}
+	return dict
+}
+
+func newFakeConnman() *fakeBus {
+	return &fakeBus{replies: map[string][]interface{}{
+		"/ " + ManagerGetProperties: {props(map[string]interface{}{
+			"State": "online",
+			"OfflineMode": false})},
+		"/ " + ManagerGetTechnologies: {[][]interface{}{
+			{dbus.ObjectPath("/net/connman/technology/wifi"), props(map[string]interface{}{
+				"Type": "wifi",
+				"Powered": true})},
+		}},
+		"/ " + ManagerGetServices: {[][]interface{}{
+			{dbus.ObjectPath("/net/connman/service/wifi_0011_home_managed_psk"), props(map[string]interface{}{
+				"Type": "wifi",
+				"Name": "home",
+				"State": "online",
+				"Strength": uint8(72),
+				"IPv4": props(map[string]interface{}{
+					"Method": "dhcp",
+					"Address": "192.168.1.20",
+					"Netmask": "255.255.255.0",
+					"Gateway": "192.168.1.1"})})},
+		}},
+		"/net/connman/technology/wifi " + TechnologyGetProperties: {props(map[string]interface{}{
+			"Name": "WiFi",
+			"Powered": true,
+			"Connected": true,
+			"Tethering": false})},
+	}}
+}
+
+func TestGetNetworkStatus(t *testing.T) {
+	status, err := NewServerOnConn(newFakeConnman()).GetNetworkStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &NetworkStatus{
+		State: "online",
+		Technologies: []TechnologyStatus{{
+			Type: "wifi",
+			Name: "WiFi",
+			Powered: true,
+			Connected: true}},
+		Services: []ServiceStatus{{
+			Id: "wifi_0011_home_managed_psk",
+			Type: "wifi",
+			Name: "home",
+			State: "online",
+			Strength: 72,
+			IPv4: AddressStatus{Method: "dhcp", Address: "192.168.1.20", Netmask: "255.255.255.0", Gateway: "192.168.1.1"}}}}
+	if !reflect.DeepEqual(status, expected) {
+		t.Errorf("got %+v, expected %+v", status, expected)
+	}
+}
+
+func TestGetNetworkStatusFailure(t *testing.T) {
+	bus := newFakeConnman()
+	delete(bus.replies, "/net/connman/technology/wifi " + TechnologyGetProperties)
+
+	if status, err := NewServerOnConn(bus).GetNetworkStatus(); err == nil {
+		t.Errorf("status %+v without the technology properties", status)
+	}
+
+	bus.replies["/ " + ManagerGetServices] = []interface{}{"not a list"}
+	if status, err := NewServerOnConn(bus).GetNetworkStatus(); err == nil {
+		t.Errorf("status %+v of malformed services", status)
+	}
+}
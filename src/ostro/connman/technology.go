@@ -18,6 +18,7 @@ type Technology struct {
 	Type string
 	Name string
 	Powered bool
+	Connected bool
 	Tethering bool
 	TetheringIdentifier string
 	TetheringPassphrase string
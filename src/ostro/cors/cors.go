@@ -0,0 +1,209 @@
+package cors
+
+import (
+	"fmt"
+	"net"
+	"time"
+	"strings"
+	"net/url"
+	"net/http"
+)
+
+const (
+	// origin pattern of the pages served by the same host on any port
+	SameHost = "same-host"
+	Any = "*"
+)
+
+// Policy decides which pages of other origins may use a server. Origins
+// are exact, like https://ui.example.com:8443, wildcard subdomains, like
+// https://*.example.com, SameHost or Any.
+type Policy struct {
+	Origins []string
+	Methods []string		// accepted by preflights
+	Headers []string		// request headers accepted by preflights
+	Expose []string			// response headers scripts may read
+	Credentials bool		// whether cookies and authentication are shared
+	MaxAge time.Duration		// how long preflights may be cached; not at all if 0
+}
+
+type origin struct {
+	scheme string
+	host string
+	port string
+}
+
+// Check reports the first malformed origin pattern of the policy, or
+// credentials shared with Any, which would let any page act as the user.
+func (me *Policy) Check() error {
+	for _, pattern := range me.Origins {
+		if pattern == Any && me.Credentials {
+			return fmt.Errorf("credentials can't be shared with any origin")
+		}
+		if pattern == SameHost || pattern == Any {
+			continue
+		}
+		o, err := parseOrigin(pattern)
+		if err != nil {
+			return err
+		}
+		if strings.Contains(strings.TrimPrefix(o.host, "*."), "*") {
+			return fmt.Errorf("invalid origin '%s': only a leading '*.' is accepted", pattern)
+		}
+	}
+	return nil
+}
+
+func parseOrigin(s string) (*origin, error) {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+		return nil, fmt.Errorf("invalid origin '%s'", s)
+	}
+
+	o := &origin{scheme: strings.ToLower(u.Scheme), host: strings.ToLower(u.Hostname()), port: u.Port()}
+	if o.port == "" {
+		switch o.scheme {
+		case "http":
+			o.port = "80"
+		case "https":
+			o.port = "443"
+		}
+	}
+
+	return o, nil
+}
+
+func hostName(hostport string) string {
+	if host, _, err := net.SplitHostPort(hostport); err == nil {
+		return strings.ToLower(host)
+	}
+	return strings.ToLower(hostport)
+}
+
+// Allowed tells whether the origin may use the server that was asked for
+// host, the Host of the request.
+func (me *Policy) Allowed(host, originHeader string) bool {
+	if me == nil || originHeader == "" {
+		return false
+	}
+
+	o, err := parseOrigin(originHeader)
+	if err != nil {
+		return false
+	}
+
+	for _, pattern := range me.Origins {
+		switch pattern {
+		case Any:
+			return true
+		case SameHost:
+			if host != "" && hostName(host) == o.host {
+				return true
+			}
+			continue
+		}
+
+		p, err := parseOrigin(pattern)
+		if err != nil || p.scheme != o.scheme || p.port != o.port {
+			continue
+		}
+		if strings.HasPrefix(p.host, "*.") {
+			if strings.HasSuffix(o.host, p.host[1:]) {
+				return true
+			}
+		} else if p.host == o.host {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Handle sets the CORS fields of the response. It answers the preflight
+// requests itself and returns true for them, so the caller is done.
+func (me *Policy) Handle(w http.ResponseWriter, r *http.Request) bool {
+	originHeader := r.Header.Get("Origin")
+	requestMethod := r.Header.Get("Access-Control-Request-Method")
+	preflight := r.Method == "OPTIONS" && originHeader != "" && requestMethod != ""
+
+	header := w.Header()
+	header.Add("Vary", "Origin")
+	if preflight {
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+	}
+
+	if !me.Allowed(r.Host, originHeader) {
+		if preflight {
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+		}
+		return preflight
+	}
+
+	// any origin gets '*', which browsers never send credentials to, even
+	// if the policy was not checked
+	if contains(me.Origins, Any, false) {
+		header.Set("Access-Control-Allow-Origin", Any)
+	} else {
+		header.Set("Access-Control-Allow-Origin", originHeader)
+		if me.Credentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+
+	if !preflight {
+		if len(me.Expose) > 0 {
+			header.Set("Access-Control-Expose-Headers", strings.Join(me.Expose, ", "))
+		}
+		return false
+	}
+
+	if !contains(me.Methods, requestMethod, false) {
+		http.Error(w, fmt.Sprintf("method '%s' not allowed", requestMethod), http.StatusForbidden)
+		return true
+	}
+
+	allowHeaders := []string{}
+	for _, name := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if !contains(me.Headers, name, true) {
+			http.Error(w, fmt.Sprintf("header '%s' not allowed", name), http.StatusForbidden)
+			return true
+		}
+		allowHeaders = append(allowHeaders, name)
+	}
+
+	header.Set("Access-Control-Allow-Methods", strings.Join(me.Methods, ", "))
+	if len(allowHeaders) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(allowHeaders, ", "))
+	}
+	if me.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", fmt.Sprintf("%d", int(me.MaxAge / time.Second)))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return true
+}
+
+func contains(list []string, s string, fold bool) bool {
+	for _, item := range list {
+		if item == s || (fold && strings.EqualFold(item, s)) {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitList splits a comma separated flag value, dropping the blanks.
+func SplitList(s string) []string {
+	list := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,56 @@
+package cors
+
+import (
+	"testing"
+	"net/http"
+	"net/http/httptest"
+)
+
+func TestAnyWithCredentials(t *testing.T) {
+	policy := &Policy{Origins: []string{Any}, Methods: []string{"GET"}, Credentials: true}
+
+	if err := policy.Check(); err == nil {
+		t.Errorf("credentials shared with any origin were accepted")
+	}
+
+	r := httptest.NewRequest("GET", "http://device/confs/local", nil)
+	r.Header.Set("Origin", "https://evil.example.com")
+	w := httptest.NewRecorder()
+	policy.Handle(w, r)
+
+	if origin := w.Header().Get("Access-Control-Allow-Origin"); origin != Any {
+		t.Errorf("origin '%s' allowed instead of '*'", origin)
+	}
+	if credentials := w.Header().Get("Access-Control-Allow-Credentials"); credentials != "" {
+		t.Errorf("credentials shared with any origin")
+	}
+}
+
+func TestCredentialsWithOrigin(t *testing.T) {
+	policy := &Policy{Origins: []string{"https://*.example.com"}, Methods: []string{"GET", "PUT"}, Credentials: true}
+
+	if err := policy.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("OPTIONS", "http://device/confs/local", nil)
+	r.Header.Set("Origin", "https://ui.example.com")
+	r.Header.Set("Access-Control-Request-Method", "PUT")
+	w := httptest.NewRecorder()
+
+	if !policy.Handle(w, r) || w.Code != http.StatusNoContent {
+		t.Fatalf("preflight was not answered: %d", w.Code)
+	}
+	if origin := w.Header().Get("Access-Control-Allow-Origin"); origin != "https://ui.example.com" {
+		t.Errorf("origin '%s' allowed", origin)
+	}
+	if credentials := w.Header().Get("Access-Control-Allow-Credentials"); credentials != "true" {
+		t.Errorf("credentials not shared with the listed origin")
+	}
+
+	r.Header.Set("Origin", "https://example.org")
+	w = httptest.NewRecorder()
+	if !policy.Handle(w, r) || w.Code != http.StatusForbidden {
+		t.Errorf("preflight of another origin: %d", w.Code)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"golang.org/x/sys/unix"
 	"github.com/coreos/etcd/client"
 	"golang.org/x/net/context"
+	"ostro/lifecycle"
 )
 
 const (
@@ -26,6 +27,7 @@ type Change struct {
 	action string
 	key string
 	value string
+	index uint64
 }
 
 func (me *Change) String() string {
@@ -35,18 +37,19 @@ func (me *Change) String() string {
 type ConFS struct {
 	etcd client.Client
 	keys client.KeysAPI
-	watcher client.Watcher
+	spaces []*namespace
+	layers map[string]layeredValue
+	report *reporter
+	push *pusher
 	ctx context.Context
-	prefix string
 	cache string
 	prefixError error
 	lengthError error
 	originError error
+	shutdownError error
 }
 
-func NewConFS(servers []string, prefix, cache string) *ConFS {
-	cfg := client.Config{Endpoints: servers}
-	
+func NewConFS(servers []string, creds *Credentials, prefix, cache string) *ConFS {
 	if len(prefix) > 0 && prefix[0] != '/' {
 		log.Fatalf("Invalid prefix '%s': does not start with '/'\n", prefix)
 	}
@@ -55,6 +58,19 @@ func NewConFS(servers []string, prefix, cache string) *ConFS {
 		prefix = ""
 	}
 
+	return NewNamespacedConFS(servers, creds, []Namespace{{prefix, ""}}, cache)
+}
+
+func NewNamespacedConFS(servers []string, creds *Credentials, namespaces []Namespace, cache string) *ConFS {
+	cfg, err := creds.clientConfig(servers)
+	if err != nil {
+		log.Fatalf("Invalid etcd credentials: %v\n", err)
+	}
+
+	if len(namespaces) < 1 {
+		log.Fatalf("No etcd namespace to watch\n")
+	}
+
 	if !strings.HasPrefix(cache, "/") {
 		log.Fatalf("relative cache path: '%s'\n", cache)
 	}
@@ -64,18 +80,42 @@ func NewConFS(servers []string, prefix, cache string) *ConFS {
 		log.Fatalf("Failed to create etcd client: %v\n", err)
 	}
 	keys := client.NewKeysAPI(etcd)
-	
-	watcher := keys.Watcher(prefix, &client.WatcherOptions{0, true})
-	
-	ctx := context.TODO()
+
+	spaces := []*namespace{}
+	for rank, ns := range namespaces {
+		if len(ns.Prefix) > 0 && ns.Prefix[0] != '/' {
+			log.Fatalf("Invalid prefix '%s': does not start with '/'\n", ns.Prefix)
+		}
+		ns.Prefix = strings.TrimRight(ns.Prefix, "/")
+		watcher := keys.Watcher(ns.Prefix, &client.WatcherOptions{AfterIndex: 0, Recursive: true})
+		spaces = append(spaces, &namespace{ns, rank, watcher})
+
+		if ns.Subtree != "" {
+			log.Printf("watching '%s' => '%s/%s'\n", ns.Prefix, cache, ns.Subtree)
+		}
+	}
+
+	ctx := lifecycle.Context()
 	prefixError := errors.New("prefix error")
 	lengthError := errors.New("only part could be written")
 	originError := errors.New("not Etcd originated")
+	shutdownError := errors.New("shutting down")
 	
-	confs := &ConFS{etcd, keys, watcher, ctx, prefix, cache, prefixError, lengthError, originError}
+	confs := &ConFS{etcd, keys, spaces, map[string]layeredValue{}, nil, nil, ctx, cache, prefixError, lengthError, originError, shutdownError}
 	
-	if err := confs.etcd.Sync(confs.ctx);  err != nil {
-		log.Fatalf("Failed to sync: %v\n", err)
+	if creds == nil || creds.SyncEndpoints {
+		if err := confs.etcd.Sync(confs.ctx);  err != nil {
+			log.Fatalf("Failed to sync: %v\n", err)
+		}
+		log.Printf("etcd endpoints: %s\n", strings.Join(confs.etcd.Endpoints(), ", "))
+	}
+
+	if creds != nil && creds.AutoSyncInterval > 0 {
+		go func() {
+			if err := confs.etcd.AutoSync(confs.ctx, creds.AutoSyncInterval); err != nil && confs.ctx.Err() == nil {
+				log.Printf("endpoint auto-sync stopped: %v\n", err)
+			}
+		}()
 	}
 	
 	return confs
@@ -87,7 +127,7 @@ func (me *ConFS) _traverse_etcd_tree(node *client.Node, changes []Change) []Chan
 	}
 
 	if !node.Dir {
-		return append(changes, Change{"set", node.Key, node.Value})
+		return append(changes, Change{"set", node.Key, node.Value, node.ModifiedIndex})
 	}
 
 	changes_out := changes
@@ -100,14 +140,20 @@ func (me *ConFS) _traverse_etcd_tree(node *client.Node, changes []Change) []Chan
 
 func (me *ConFS) TraverseEtcdTree() ([]Change, error) {
 	opts := client.GetOptions{Recursive:true, Sort:true, Quorum:false}
+	changes := []Change{}
 
-	resp, err := me.keys.Get(me.ctx, me.prefix, &opts)
-	if err != nil {
-		log.Printf("failed to travers on etcd tree '%s': %v\n", me.prefix, err)
-		return nil, err
-	}
+	for _, ns := range me.spaces {
+		resp, err := me.keys.Get(me.ctx, ns.Prefix, &opts)
+		if err != nil {
+			if len(me.spaces) > 1 && client.IsKeyNotFound(err) {
+				continue
+			}
+			log.Printf("failed to travers on etcd tree '%s': %v\n", ns.Prefix, err)
+			return nil, err
+		}
 	
-	changes := me._traverse_etcd_tree(resp.Node, []Change{})
+		changes = me._traverse_etcd_tree(resp.Node, changes)
+	}
 	
 	return changes, nil
 }
@@ -116,44 +162,66 @@ func (me *ConFS) PollForChanges() (<-chan []Change, <-chan error) {
 	change_chan := make(chan []Change, 5)
 	err_chan := make(chan error)
 	
-	go func() {
-		for {
-			resp , err := me.watcher.Next(me.ctx)
-			if err != nil {
-				err_chan <- err
-			} else {
-				change_chan <- []Change{{resp.Action, resp.Node.Key, resp.Node.Value}}
+	for _, ns := range me.spaces {
+		go func(watcher client.Watcher) {
+			for {
+				resp , err := watcher.Next(me.ctx)
+				if me.ctx.Err() != nil {
+					return
+				}
+				if err != nil {
+					err_chan <- err
+				} else {
+					change_chan <- []Change{{resp.Action, resp.Node.Key, resp.Node.Value, resp.Node.ModifiedIndex}}
+				}
 			}
-		}
-
-		close(err_chan)
-		close(change_chan)
-	} ()
+		} (ns.watcher)
+	}
 	
 	return change_chan, err_chan
 }
 
-func (me *ConFS) _get_cache_path(key string) (string, error) {
-	if !strings.HasPrefix(key, me.prefix) {
-		return "", me.prefixError
+func (me *ConFS) _get_namespace(key string) (*namespace, error) {
+	for i := len(me.spaces) - 1;  i >= 0;  i-- {
+		ns := me.spaces[i]
+		if ns.Prefix == "" || key == ns.Prefix || strings.HasPrefix(key, ns.Prefix + "/") {
+			return ns, nil
+		}
+	}
+
+	return nil, me.prefixError
+}
+
+func (me *ConFS) _get_cache_root(ns *namespace) string {
+	if ns.Subtree == "" {
+		return me.cache
 	}
 
-	return me.cache + strings.TrimPrefix(key, me.prefix), nil
+	return me.cache + "/" + ns.Subtree
 }
 
-func (me *ConFS) _set_file(key string, value string, silent bool) error {
-	cache_path, err := me._get_cache_path(key)
+func (me *ConFS) _get_cache_path(key string) (string, *namespace, error) {
+	ns, err := me._get_namespace(key)
 	if err != nil {
-		return err
+		return "", nil, err
 	}
-	
+
+	return me._get_cache_root(ns) + strings.TrimPrefix(key, ns.Prefix), ns, nil
+}
+
+func (me *ConFS) _set_file(key, cache_path string, value string, silent bool) error {
+	if !lifecycle.Begin() {
+		return me.shutdownError
+	}
+	defer lifecycle.End()
+
 	var jsonData interface{}
 	var content []byte
 	if err := json.Unmarshal(bytes.Trim([]byte(value), " \t\n\r"), &jsonData); err != nil {
 		log.Printf("'%s' contains invalid JSON '%s': %v\n", key, value, err)
 		return err
 	}
-	content, err = json.Marshal(jsonData)
+	content, err := json.Marshal(jsonData)
 	if err != nil {
 		log.Printf("Error during canonizing '%s': %v\n", key, err)
 		return err
@@ -184,6 +252,7 @@ func (me *ConFS) _set_file(key string, value string, silent bool) error {
 			_, err := unix.Getxattr(cache_path, HashAttr, file_hash)
 			
 			if err == nil && bytes.Equal(content_hash, file_hash) {
+				me.report.setApplied(cache_path, key, content_hash)
 				if !silent {
 					log.Printf("Do not copy '%s' <= '%s': No change\n", cache_path, content)
 				}
@@ -240,16 +309,18 @@ func (me *ConFS) _set_file(key string, value string, silent bool) error {
 			return err
 		}
 	}
+
+	me.report.setApplied(cache_path, key, content_hash)
 	
 	return nil
 }
 
-func (me *ConFS) _rm_file(key string, silent bool) error {
-	cache_path, err := me._get_cache_path(key)
-	if err != nil {
-		return err
+func (me *ConFS) _rm_file(cache_path, dir_prefix string, silent bool) error {
+	if !lifecycle.Begin() {
+		return me.shutdownError
 	}
-	
+	defer lifecycle.End()
+
 	if _, err := os.Stat(cache_path); err == nil {
 		log.Printf("removing '%s'\n", cache_path)
 
@@ -264,7 +335,6 @@ func (me *ConFS) _rm_file(key string, silent bool) error {
 	}
 
 	dir_path := cache_path
-	dir_prefix,_ := me._get_cache_path("")
 	for {
 		dir_path = filepath.Dir(dir_path)
 
@@ -288,18 +358,118 @@ func (me *ConFS) _rm_file(key string, silent bool) error {
 }
 
 func (me *ConFS) SyncFiles(changes []Change, silent bool) error {
+	var firstErr error
+	synced := false
+
 	for _, c := range changes {
-		switch c.action {
-		case "set":
-			if err := me._set_file(c.key, c.value, silent); err != nil {
-				return err
+		if me.report.covers(c.key) {
+			continue
+		}
+		synced = true
+
+		cache_path, ns, err := me._get_cache_path(c.key)
+		if err == nil {
+			switch c.action {
+			case "set", "create", "update", "compareAndSwap":
+				err = me._set_layer(c, cache_path, ns, silent)
+			case "delete", "expire", "compareAndDelete":
+				err = me._rm_layer(c, cache_path, ns, silent)
+			}
+		}
+
+		me.report.setFailure(c.key, err)
+
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if synced || len(changes) == 0 {
+		me._publish_status()
+	}
+	
+	return firstErr
+}
+
+func (me *ConFS) _set_layer(c Change, cache_path string, ns *namespace, silent bool) error {
+	layers := me.layers[cache_path]
+	if layers == nil {
+		layers = layeredValue{}
+		me.layers[cache_path] = layers
+	}
+	layers[ns.rank] = c
+
+	if rank, _, _ := layers.top(); rank != ns.rank {
+		if !silent {
+			log.Printf("Do not copy '%s' <= '%s': shadowed by '%s'\n", cache_path, c.key, me.spaces[rank].Prefix)
+		}
+		return nil
+	}
+
+	if !me.push.acceptRemoteSet(me, c, cache_path) {
+		return nil
+	}
+
+	return me._set_file(c.key, cache_path, c.value, silent)
+}
+
+// _rm_layer removes the values of a namespace at or under cache_path. Each
+// file falls back to the lower-ranked namespaces still holding it, or goes
+// away if there is none left.
+func (me *ConFS) _rm_layer(c Change, cache_path string, ns *namespace, silent bool) error {
+	orphans := map[string]string{}
+
+	for path, layers := range me.layers {
+		if path != cache_path && !strings.HasPrefix(path, cache_path + "/") {
+			continue
+		}
+		removed, exists := layers[ns.rank]
+		if !exists {
+			continue
+		}
+
+		top, _, _ := layers.top()
+		delete(layers, ns.rank)
+
+		if rank, fallback, ok := layers.top(); ok {
+			if top == ns.rank {
+				log.Printf("'%s' falls back to '%s'\n", path, me.spaces[rank].Prefix)
+				if err := me._set_file(fallback.key, path, fallback.value, silent); err != nil {
+					return err
+				}
 			}
-		case "delete":
-			if err := me._rm_file(c.key, silent); err != nil {
+			continue
+		}
+
+		delete(me.layers, path)
+		orphans[path] = removed.key
+	}
+
+	if len(orphans) > 0 {
+		root := me._get_cache_root(ns)
+		for path, key := range orphans {
+			if !me.push.acceptRemoteDelete(me, Change{c.action, key, "", c.index}, path) {
+				continue
+			}
+			me.report.removeApplied(path)
+			if err := me._rm_file(path, root, silent); err != nil {
 				return err
 			}
 		}
+		return nil
 	}
-	
-	return nil
+
+	for path := range me.layers {
+		if path == cache_path || strings.HasPrefix(path, cache_path + "/") {
+			return nil
+		}
+	}
+
+	if !me.push.acceptRemoteDelete(me, c, cache_path) {
+		return nil
+	}
+
+	me.report.removeApplied(cache_path)
+
+	return me._rm_file(cache_path, me._get_cache_root(ns), silent)
 }
@@ -0,0 +1,108 @@
+package confs
+
+import (
+	"os"
+	"errors"
+	"sync"
+	"testing"
+	"path/filepath"
+	"golang.org/x/sys/unix"
+	"github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+)
+
+// fakeKeys is an etcd key space in memory; the methods the tests do not
+// need panic through the nil KeysAPI.
+type fakeKeys struct {
+	client.KeysAPI
+	sync.Mutex
+	index uint64
+	values map[string]*client.Node
+	sets []string
+}
+
+func newFakeKeys() *fakeKeys {
+	return &fakeKeys{index: 1, values: map[string]*client.Node{}}
+}
+
+func (me *fakeKeys) Get(ctx context.Context, key string, opts *client.GetOptions) (*client.Response, error) {
+	me.Lock()
+	defer me.Unlock()
+
+	node, found := me.values[key]
+	if !found {
+		return nil, client.Error{Code: client.ErrorCodeKeyNotFound, Message: "Key not found", Cause: key, Index: me.index}
+	}
+
+	return &client.Response{Action: "get", Node: node, Index: me.index}, nil
+}
+
+func (me *fakeKeys) Set(ctx context.Context, key, value string, opts *client.SetOptions) (*client.Response, error) {
+	me.Lock()
+	defer me.Unlock()
+
+	node, found := me.values[key]
+	if opts != nil {
+		if opts.PrevExist == client.PrevNoExist && found || opts.PrevExist == client.PrevExist && !found ||
+			opts.PrevIndex != 0 && (!found || node.ModifiedIndex != opts.PrevIndex) {
+			return nil, client.Error{Code: client.ErrorCodeTestFailed, Message: "Compare failed", Cause: key, Index: me.index}
+		}
+	}
+
+	me.index++
+	me.values[key] = &client.Node{Key: key, Value: value, ModifiedIndex: me.index}
+	me.sets = append(me.sets, key)
+
+	return &client.Response{Action: "set", Node: me.values[key], Index: me.index}, nil
+}
+
+// put changes a key as another etcd client would and returns the change
+// the watcher would deliver.
+func (me *fakeKeys) put(key, value string) Change {
+	me.Lock()
+	defer me.Unlock()
+
+	me.index++
+	me.values[key] = &client.Node{Key: key, Value: value, ModifiedIndex: me.index}
+
+	return Change{"set", key, value, me.index}
+}
+
+func (me *fakeKeys) setCalls() []string {
+	me.Lock()
+	defer me.Unlock()
+
+	return append([]string{}, me.sets...)
+}
+
+func newTestConFS(t *testing.T, keys *fakeKeys, namespaces ...Namespace) *ConFS {
+	cache := t.TempDir()
+
+	probe := filepath.Join(cache, ".probe")
+	if err := os.WriteFile(probe, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Setxattr(probe, OriginAttr, []byte(EtcdOriginated), 0); err != nil {
+		t.Skipf("no user xattrs in '%s': %v", cache, err)
+	}
+	os.Remove(probe)
+
+	spaces := []*namespace{}
+	for rank, ns := range namespaces {
+		spaces = append(spaces, &namespace{ns, rank, nil})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	return &ConFS{
+		keys: keys,
+		spaces: spaces,
+		layers: map[string]layeredValue{},
+		ctx: ctx,
+		cache: cache,
+		prefixError: errors.New("prefix error"),
+		lengthError: errors.New("only part could be written"),
+		originError: errors.New("not Etcd originated"),
+		shutdownError: errors.New("shutting down")}
+}
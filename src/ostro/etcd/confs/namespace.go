@@ -0,0 +1,118 @@
+package confs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"io/ioutil"
+	"github.com/coreos/etcd/client"
+)
+
+const (
+	CommonSubtree = "common"
+	LocalSubtree = "local"
+)
+
+type Namespace struct {
+	Prefix string
+	Subtree string
+}
+
+// FleetNamespaces returns the etcd prefixes a device has to watch in
+// increasing order of precedence: fleet defaults, the groups in the order
+// they were given and finally the device itself.
+func FleetNamespaces(root, device string, groups []string) []Namespace {
+	root = strings.TrimRight(root, "/")
+
+	spaces := []Namespace{{root + "/defaults", CommonSubtree}}
+
+	for _, g := range groups {
+		spaces = append(spaces, Namespace{root + "/groups/" + g, CommonSubtree})
+	}
+
+	return append(spaces, Namespace{root + "/devices/" + device, LocalSubtree})
+}
+
+// DeviceIdentity resolves the device id and the group membership. Values
+// given explicitly take precedence over the ones read from files.
+func DeviceIdentity(id, idFile, groups, groupsFile string) (string, []string, error) {
+	var groupList []string
+
+	if id == "" && idFile != "" {
+		content, err := ioutil.ReadFile(idFile)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read device id: %v", err)
+		}
+		id = strings.Trim(string(content), " \t\r\n")
+	}
+
+	if !isValidName(id) {
+		return "", nil, fmt.Errorf("invalid device id '%s'", id)
+	}
+
+	if groups != "" {
+		groupList = strings.Split(strings.Replace(groups, " ", "", -1), ",")
+	} else if groupsFile != "" {
+		content, err := ioutil.ReadFile(groupsFile)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read group membership: %v", err)
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			if g := strings.Trim(line, " \t\r"); g != "" && g[0] != '#' {
+				groupList = append(groupList, g)
+			}
+		}
+	}
+
+	seen := map[string]bool{}
+	uniqueGroups := []string{}
+
+	for _, g := range groupList {
+		if !isValidName(g) {
+			return "", nil, fmt.Errorf("invalid group name '%s'", g)
+		}
+		if !seen[g] {
+			seen[g] = true
+			uniqueGroups = append(uniqueGroups, g)
+		}
+	}
+
+	return id, uniqueGroups, nil
+}
+
+// isValidName accepts names that are one segment of a key or path; "."
+// and ".." would step out of the fleet root.
+func isValidName(name string) bool {
+	if name == "" || name == "." || name == ".." {
+		return false
+	}
+	for i := 0;  i < len(name);  i++ {
+		c := name[i]
+		if c != '-' && c != '_' && c != '.' && (c < '0' || (c > '9' && c < 'A') || (c > 'Z' && c < 'a') || c > 'z') {
+			return false
+		}
+	}
+	return true
+}
+
+
+type namespace struct {
+	Namespace
+	rank int
+	watcher client.Watcher
+}
+
+type layeredValue map[int]Change
+
+func (me layeredValue) top() (int, Change, bool) {
+	ranks := []int{}
+	for r := range me {
+		ranks = append(ranks, r)
+	}
+	if len(ranks) == 0 {
+		return -1, Change{}, false
+	}
+	sort.Ints(ranks)
+	r := ranks[len(ranks)-1]
+	return r, me[r], true
+}
@@ -0,0 +1,137 @@
+package confs
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"io/ioutil"
+	"encoding/json"
+	"path/filepath"
+	dropzone "ostro/confs"
+)
+
+func TestFleetNamespaces(t *testing.T) {
+	spaces := FleetNamespaces("/fleet/", "device1", []string{"lab", "floor2"})
+
+	expected := []Namespace{
+		{"/fleet/defaults", CommonSubtree},
+		{"/fleet/groups/lab", CommonSubtree},
+		{"/fleet/groups/floor2", CommonSubtree},
+		{"/fleet/devices/device1", LocalSubtree},
+	}
+	if !reflect.DeepEqual(spaces, expected) {
+		t.Errorf("got %v, expected %v", spaces, expected)
+	}
+}
+
+func TestDeviceIdentity(t *testing.T) {
+	dir := t.TempDir()
+	idFile, groupsFile := filepath.Join(dir, "id"), filepath.Join(dir, "groups")
+	if err := ioutil.WriteFile(idFile, []byte("device1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(groupsFile, []byte("# groups\nlab\n\n floor2 \nlab\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct{
+		id, idFile, groups, groupsFile string
+		device string
+		groupList []string
+	}{
+		{"", idFile, "", groupsFile, "device1", []string{"lab", "floor2"}},
+		{"device2", idFile, "a, b,a", groupsFile, "device2", []string{"a", "b"}},
+		{"device.v2", "", "", "", "device.v2", []string{}},
+	} {
+		device, groups, err := DeviceIdentity(c.id, c.idFile, c.groups, c.groupsFile)
+		if err != nil {
+			t.Errorf("%v: %v", c, err)
+		} else if device != c.device || !reflect.DeepEqual(groups, c.groupList) {
+			t.Errorf("%v: got '%s' %v", c, device, groups)
+		}
+	}
+
+	for _, c := range []struct{
+		id, groups string
+	}{
+		{".", ""},
+		{"..", ""},
+		{"", ""},
+		{"a/b", ""},
+		{"device1", "lab,.."},
+		{"device1", "."},
+		{"device1", "lab,,floor2"},
+	} {
+		if _, _, err := DeviceIdentity(c.id, "", c.groups, ""); err == nil {
+			t.Errorf("id '%s' groups '%s' accepted", c.id, c.groups)
+		}
+	}
+}
+
+func readCache(t *testing.T, path string) map[string]interface{} {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := map[string]interface{}{}
+	if err := json.Unmarshal(content, &value); err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func TestLayerPrecedence(t *testing.T) {
+	keys := newFakeKeys()
+	cfs := newTestConFS(t, keys, FleetNamespaces("/fleet", "device1", []string{"lab", "floor2"})...)
+	common := filepath.Join(cfs.cache, CommonSubtree, "net/wifi")
+	local := filepath.Join(cfs.cache, LocalSubtree, "net/wifi")
+
+	expect := func(step, path, ssid string) {
+		if value := readCache(t, path); value["ssid"] != ssid {
+			t.Errorf("%s: '%s' has %v, expected ssid '%s'", step, path, value, ssid)
+		}
+	}
+	sync := func(changes ...Change) {
+		if err := cfs.SyncFiles(changes, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a group outranks the defaults whatever the order the changes come in
+	sync(keys.put("/fleet/groups/lab/net/wifi", `{"ssid": "lab"}`))
+	sync(keys.put("/fleet/defaults/net/wifi", `{"ssid": "default", "mtu": 1500}`))
+	expect("defaults after a group", common, "lab")
+
+	// a later group outranks an earlier one
+	sync(keys.put("/fleet/groups/floor2/net/wifi", `{"ssid": "floor2"}`))
+	expect("later group", common, "floor2")
+	sync(keys.put("/fleet/groups/lab/net/wifi", `{"ssid": "lab2"}`))
+	expect("earlier group updated", common, "floor2")
+
+	// the device goes to its own subtree and leaves common alone
+	sync(keys.put("/fleet/devices/device1/net/wifi", `{"ssid": "device"}`))
+	expect("device", local, "device")
+	expect("common beside the device", common, "floor2")
+
+	// the layers merge common first, so the device overrides the groups
+	merged := map[string]interface{}{}
+	for _, path := range []string{common, local} {
+		if err := dropzone.MergeFragment(readCache(t, path), merged); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if merged["ssid"] != "device" {
+		t.Errorf("merged layers: got %v", merged)
+	}
+
+	// removing the top group falls back to the next one, then the defaults
+	sync(Change{"delete", "/fleet/groups/floor2/net/wifi", "", 0})
+	expect("top group removed", common, "lab2")
+	sync(Change{"delete", "/fleet/groups/lab/net/wifi", "", 0})
+	expect("groups removed", common, "default")
+	sync(Change{"delete", "/fleet/defaults/net/wifi", "", 0})
+	if _, err := os.Stat(common); !os.IsNotExist(err) {
+		t.Errorf("'%s' left without any layer: %v", common, err)
+	}
+	expect("device after the common layers", local, "device")
+}
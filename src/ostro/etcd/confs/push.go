@@ -0,0 +1,404 @@
+package confs
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"bytes"
+	"strings"
+	"io/ioutil"
+	"crypto/md5"
+	"encoding/json"
+	"golang.org/x/sys/unix"
+	"github.com/coreos/etcd/client"
+	"ostro/watch"
+	"ostro/lifecycle"
+)
+
+type PushOptions struct {
+	Subtree string
+	Origins []string
+}
+
+// pusher mirrors the locally originated files of a drop zone subtree to
+// the device namespace. A change of one side since the last sync replaces
+// the other side; when both sides changed a file, the side whose origin
+// comes first in the origin list wins, whichever wrote last.
+type pusher struct {
+	ns *namespace
+	root string
+	priority map[string]int
+	index map[string]uint64
+	content map[string]string
+	pushed map[string]string
+	watcher *watch.Watcher
+}
+
+func (me *ConFS) EnablePush(opts PushOptions) error {
+	var device *namespace
+
+	if me.push != nil {
+		return fmt.Errorf("two-way synchronisation is already enabled")
+	}
+
+	for _, ns := range me.spaces {
+		if ns.Subtree == LocalSubtree {
+			device = ns
+		}
+	}
+	if device == nil {
+		return fmt.Errorf("two-way synchronisation needs a device namespace")
+	}
+
+	subtree := strings.TrimRight(opts.Subtree, "/")
+	if subtree != "/" + LocalSubtree && !strings.HasPrefix(subtree, "/" + LocalSubtree + "/") {
+		return fmt.Errorf("subtree '%s' is not under '/%s'", opts.Subtree, LocalSubtree)
+	}
+
+	priority := map[string]int{}
+	for i, o := range opts.Origins {
+		if _, dup := priority[o]; !dup {
+			priority[o] = i
+		}
+	}
+	if _, found := priority[EtcdOriginated]; !found {
+		return fmt.Errorf("origin list must contain '%s'", EtcdOriginated)
+	}
+
+	root := me.cache + subtree
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+
+	watcher, err := watch.NewWatcher(root)
+	if err != nil {
+		return err
+	}
+
+	lifecycle.OnShutdown(func() {
+		watcher.Close()
+	})
+
+	me.push = &pusher{
+		ns: device,
+		root: root,
+		priority: priority,
+		index: map[string]uint64{},
+		content: map[string]string{},
+		pushed: map[string]string{},
+		watcher: watcher}
+
+	log.Printf("pushing '%s' to '%s' (origins: %s)\n", root, device.Prefix, strings.Join(opts.Origins, ", "))
+
+	return nil
+}
+
+func (me *ConFS) PollForLocalChanges() (<-chan []watch.Event, <-chan error) {
+	if me.push == nil {
+		return nil, nil
+	}
+
+	event_chan := make(chan []watch.Event, 5)
+
+	go func(w *watch.Watcher) {
+		event_chan <- w.Files()
+
+		for ev := range w.Events {
+			event_chan <- []watch.Event{ev}
+		}
+
+		close(event_chan)
+	} (me.push.watcher)
+
+	return event_chan, me.push.watcher.Errors
+}
+
+func (me *ConFS) PushFiles(events []watch.Event) error {
+	var firstErr error
+
+	if me.push == nil {
+		return nil
+	}
+
+	for _, ev := range events {
+		var err error
+
+		key, managed := me.push.key(me, ev.Path)
+		if !managed {
+			continue
+		}
+
+		switch ev.Op {
+		case watch.Write:
+			err = me._push_file(key, ev)
+		case watch.Remove:
+			err = me._push_removal(key, ev)
+		}
+
+		if err != nil {
+			log.Printf("Failed to push '%s' => '%s': %v\n", ev.Path, key, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return firstErr
+}
+
+func (me *ConFS) _push_file(key string, ev watch.Event) error {
+	if !me.push.pushable(ev.Origin) {
+		return nil
+	}
+
+	value, err := readCanonical(ev.Path)
+	if err != nil {
+		return err
+	}
+
+	resp, err := me.keys.Get(me.ctx, key, nil)
+	if err != nil {
+		if !client.IsKeyNotFound(err) {
+			return err
+		}
+		opts := client.SetOptions{PrevExist: client.PrevNoExist}
+		if resp, err = me.keys.Set(me.ctx, key, value, &opts); err != nil {
+			return err
+		}
+		me.push.synced(key, resp.Node.ModifiedIndex, ev.Origin, value)
+		log.Printf("pushed '%s' => '%s'\n", ev.Path, key)
+		return nil
+	}
+
+	if remote, err := canonical([]byte(resp.Node.Value)); err == nil && remote == value {
+		me.push.synced(key, resp.Node.ModifiedIndex, ev.Origin, value)
+		return nil
+	}
+
+	if resp.Node.ModifiedIndex != me.push.index[key] && (!me.push.changedLocally(key, value) || me.push.remoteWins(ev.Origin)) {
+		if me.push.changedLocally(key, value) {
+			log.Printf("conflict on '%s': '%s' change is overridden by '%s'\n", key, ev.Origin, EtcdOriginated)
+		}
+		me.push.tookOver(key, resp.Node.ModifiedIndex, resp.Node.Value)
+		if err := takeOver(ev.Path); err != nil {
+			return err
+		}
+		return me._set_file(key, ev.Path, resp.Node.Value, false)
+	}
+
+	opts := client.SetOptions{PrevIndex: resp.Node.ModifiedIndex}
+	if resp, err = me.keys.Set(me.ctx, key, value, &opts); err != nil {
+		if isCompareFailed(err) {
+			log.Printf("conflict on '%s': changed remotely while pushing\n", key)
+			return nil
+		}
+		return err
+	}
+	me.push.synced(key, resp.Node.ModifiedIndex, ev.Origin, value)
+	log.Printf("pushed '%s' => '%s'\n", ev.Path, key)
+
+	return nil
+}
+
+func (me *ConFS) _push_removal(key string, ev watch.Event) error {
+	origin, pushed := me.push.pushed[key]
+	if !pushed {
+		return nil
+	}
+	if _, err := os.Stat(ev.Path); err == nil {
+		return nil
+	}
+
+	delete(me.push.pushed, key)
+
+	opts := client.DeleteOptions{PrevIndex: me.push.index[key]}
+	if _, err := me.keys.Delete(me.ctx, key, &opts); err != nil {
+		if client.IsKeyNotFound(err) {
+			return nil
+		}
+		if isCompareFailed(err) {
+			log.Printf("conflict on '%s': '%s' removal is overridden by remote change\n", key, origin)
+			return nil
+		}
+		return err
+	}
+	delete(me.push.index, key)
+	delete(me.push.content, key)
+	log.Printf("removed '%s' after '%s' was removed\n", key, ev.Path)
+
+	return nil
+}
+
+func (me *pusher) acceptRemoteSet(confs *ConFS, c Change, cache_path string) bool {
+	if me == nil || !me.manages(cache_path) {
+		return true
+	}
+
+	lastIndex, known := me.index[c.key]
+	me.index[c.key] = c.index
+
+	origin, err := watch.GetOrigin(cache_path)
+	if err != nil || origin == EtcdOriginated || !me.pushable(origin) {
+		return true
+	}
+
+	local, err := readCanonical(cache_path)
+	if err != nil {
+		log.Printf("Can't compare '%s' with '%s': %v\n", cache_path, c.key, err)
+		return true
+	}
+
+	if remote, err := canonical([]byte(c.value)); err == nil && remote == local {
+		me.synced(c.key, c.index, origin, local)
+		return false
+	}
+
+	if known && c.index == lastIndex {
+		// the remote value is the synced one, the local change is
+		// being pushed
+		return false
+	}
+
+	if !me.changedLocally(c.key, local) || me.remoteWins(origin) {
+		if me.changedLocally(c.key, local) {
+			log.Printf("conflict on '%s': '%s' change is overridden by '%s'\n", c.key, origin, EtcdOriginated)
+		}
+		me.tookOver(c.key, c.index, c.value)
+		if err := takeOver(cache_path); err != nil {
+			log.Printf("Can't take over '%s': %v\n", cache_path, err)
+		}
+		return true
+	}
+
+	log.Printf("conflict on '%s': '%s' change is overridden by '%s'\n", c.key, EtcdOriginated, origin)
+
+	opts := client.SetOptions{PrevIndex: c.index}
+	if resp, err := confs.keys.Set(confs.ctx, c.key, local, &opts); err != nil {
+		log.Printf("Failed to push '%s' => '%s': %v\n", cache_path, c.key, err)
+	} else {
+		me.synced(c.key, resp.Node.ModifiedIndex, origin, local)
+	}
+
+	return false
+}
+
+func (me *pusher) acceptRemoteDelete(confs *ConFS, c Change, cache_path string) bool {
+	if me == nil || !me.manages(cache_path) {
+		return true
+	}
+
+	origin, pushed := me.pushed[c.key]
+	synced, known := me.content[c.key]
+	delete(me.pushed, c.key)
+	delete(me.index, c.key)
+	delete(me.content, c.key)
+
+	if !pushed || me.remoteWins(origin) {
+		return true
+	}
+
+	local, err := readCanonical(cache_path)
+	if err != nil || known && local == synced {
+		return true
+	}
+
+	log.Printf("conflict on '%s': removal is overridden by '%s'\n", c.key, origin)
+
+	opts := client.SetOptions{PrevExist: client.PrevNoExist}
+	if resp, err := confs.keys.Set(confs.ctx, c.key, local, &opts); err != nil {
+		log.Printf("Failed to push '%s' => '%s': %v\n", cache_path, c.key, err)
+	} else {
+		me.synced(c.key, resp.Node.ModifiedIndex, origin, local)
+	}
+
+	return false
+}
+
+func (me *pusher) manages(cache_path string) bool {
+	return cache_path == me.root || strings.HasPrefix(cache_path, me.root + "/")
+}
+
+func (me *pusher) key(confs *ConFS, path string) (string, bool) {
+	if !me.manages(path) {
+		return "", false
+	}
+
+	return me.ns.Prefix + strings.TrimPrefix(path, confs._get_cache_root(me.ns)), true
+}
+
+func (me *pusher) pushable(origin string) bool {
+	_, listed := me.priority[origin]
+	return listed && origin != EtcdOriginated
+}
+
+func (me *pusher) remoteWins(origin string) bool {
+	prio, listed := me.priority[origin]
+	return !listed || me.priority[EtcdOriginated] < prio
+}
+
+// synced records the value both sides agree on, for telling later which
+// side changed.
+func (me *pusher) synced(key string, index uint64, origin, content string) {
+	me.index[key] = index
+	me.content[key] = content
+	me.pushed[key] = origin
+}
+
+// tookOver records that the remote value replaces the local file.
+func (me *pusher) tookOver(key string, index uint64, value string) {
+	delete(me.pushed, key)
+	me.index[key] = index
+	if remote, err := canonical([]byte(value)); err == nil {
+		me.content[key] = remote
+	} else {
+		delete(me.content, key)
+	}
+}
+
+// changedLocally tells whether the local file differs from the last synced
+// value; a file never synced counts as changed.
+func (me *pusher) changedLocally(key, local string) bool {
+	synced, known := me.content[key]
+	return !known || synced != local
+}
+
+func takeOver(path string) error {
+	if err := unix.Setxattr(path, OriginAttr, []byte(EtcdOriginated), 0); err != nil {
+		return err
+	}
+	if _, err := watch.GetHash(path); err != nil {
+		return unix.Setxattr(path, HashAttr, make([]byte, md5.Size), 0)
+	}
+	return nil
+}
+
+func isCompareFailed(err error) bool {
+	cerr, ok := err.(client.Error)
+	return ok && cerr.Code == client.ErrorCodeTestFailed
+}
+
+func canonical(content []byte) (string, error) {
+	var value interface{}
+
+	if err := json.Unmarshal(bytes.Trim(content, " \t\n\r"), &value); err != nil {
+		return "", err
+	}
+
+	canonical, err := json.Marshal(value)
+
+	return string(canonical), err
+}
+
+func readCanonical(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	value, err := canonical(content)
+	if err != nil {
+		return "", fmt.Errorf("only JSON content can be pushed: %v", err)
+	}
+
+	return value, nil
+}
@@ -0,0 +1,137 @@
+package confs
+
+import (
+	"os"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+	"golang.org/x/sys/unix"
+	"ostro/watch"
+)
+
+const testKey = "/fleet/devices/device1/net/wifi"
+
+// newPushTest returns a forwarder pushing the drop zone with the REST
+// changes winning the conflicts, and the local file that has been pushed
+// as {"ssid":"home"} and mirrored back.
+func newPushTest(t *testing.T) (*ConFS, *fakeKeys, string) {
+	keys := newFakeKeys()
+	cfs := newTestConFS(t, keys, Namespace{"/fleet/devices/device1", LocalSubtree})
+
+	root := filepath.Join(cfs.cache, LocalSubtree)
+	cfs.push = &pusher{
+		ns: cfs.spaces[0],
+		root: root,
+		priority: map[string]int{"Rest": 0, EtcdOriginated: 1},
+		index: map[string]uint64{},
+		content: map[string]string{},
+		pushed: map[string]string{}}
+
+	path := filepath.Join(root, "net/wifi")
+	writeLocal(t, path, `{"ssid": "home"}`)
+
+	if err := cfs.PushFiles([]watch.Event{{Op: watch.Write, Path: path, Origin: "Rest"}}); err != nil {
+		t.Fatal(err)
+	}
+	node := keys.values[testKey]
+	if node == nil {
+		t.Fatalf("'%s' was not pushed", path)
+	}
+	if err := cfs.SyncFiles([]Change{{"set", node.Key, node.Value, node.ModifiedIndex}}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	return cfs, keys, path
+}
+
+func writeLocal(t *testing.T, path, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Setxattr(path, OriginAttr, []byte("Rest"), 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readLocal(t *testing.T, path string) string {
+	content, err := readCanonical(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestRemoteSetWithoutLocalChange(t *testing.T) {
+	cfs, keys, path := newPushTest(t)
+	pushes := len(keys.setCalls())
+
+	if err := cfs.SyncFiles([]Change{keys.put(testKey, `{"ssid": "office"}`)}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if content := readLocal(t, path); content != `{"ssid":"office"}` {
+		t.Errorf("remote change was not applied: %s", content)
+	}
+	if origin, _ := watch.GetOrigin(path); origin != EtcdOriginated {
+		t.Errorf("file was not taken over: origin '%s'", origin)
+	}
+	if len(keys.setCalls()) != pushes {
+		t.Errorf("stale local value was pushed back: %v", keys.setCalls())
+	}
+}
+
+func TestRemoteSetWithLocalChange(t *testing.T) {
+	cfs, keys, path := newPushTest(t)
+
+	writeLocal(t, path, `{"ssid": "cafe"}`)
+	if err := cfs.SyncFiles([]Change{keys.put(testKey, `{"ssid": "office"}`)}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if content := readLocal(t, path); content != `{"ssid":"cafe"}` {
+		t.Errorf("local change lost the conflict: %s", content)
+	}
+	if value := keys.values[testKey].Value; value != `{"ssid":"cafe"}` {
+		t.Errorf("local change was not pushed: %s", value)
+	}
+}
+
+func TestLocalWriteWithoutLocalChange(t *testing.T) {
+	cfs, keys, path := newPushTest(t)
+
+	keys.put(testKey, `{"ssid": "office"}`)
+	if err := cfs.PushFiles([]watch.Event{{Op: watch.Write, Path: path, Origin: "Rest"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if value := keys.values[testKey].Value; value != `{"ssid": "office"}` {
+		t.Errorf("stale local value overwrote the remote one: %s", value)
+	}
+	if content := readLocal(t, path); content != `{"ssid":"office"}` {
+		t.Errorf("remote change was not applied: %s", content)
+	}
+}
+
+func TestRemoteDeleteWithoutLocalChange(t *testing.T) {
+	cfs, keys, path := newPushTest(t)
+
+	keys.Lock()
+	delete(keys.values, testKey)
+	keys.index++
+	change := Change{"delete", testKey, "", keys.index}
+	keys.Unlock()
+
+	if err := cfs.SyncFiles([]Change{change}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("unchanged file survived the remote removal: %v", err)
+	}
+	if _, found := keys.values[testKey]; found {
+		t.Errorf("removed key was pushed again")
+	}
+}
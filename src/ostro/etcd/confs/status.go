@@ -0,0 +1,170 @@
+package confs
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"strings"
+	"time"
+	"encoding/json"
+	"github.com/coreos/etcd/client"
+)
+
+var (
+	Version = "0.1"
+)
+
+type Status struct {
+	Device string `json:"device"`
+	Version string `json:"version"`
+	Timestamp string `json:"timestamp"`
+	Applied map[string]string `json:"applied"`
+	Failures map[string]string `json:"failures"`
+}
+
+type appliedFile struct {
+	key string
+	hash string
+}
+
+type reporter struct {
+	sync.Mutex
+	prefix string
+	key string
+	device string
+	ttl time.Duration
+	applied map[string]appliedFile
+	failures map[string]string
+}
+
+// covers tells whether a key is a status, of this or of another device.
+// Those are not configuration and publishing them must not sync again.
+func (me *reporter) covers(key string) bool {
+	return me != nil && (key == me.prefix || strings.HasPrefix(key, me.prefix + "/"))
+}
+
+func (me *reporter) setApplied(cache_path, key string, hash []byte) {
+	if me != nil {
+		me.Lock()
+		me.applied[cache_path] = appliedFile{key, fmt.Sprintf("%x", hash)}
+		me.Unlock()
+	}
+}
+
+func (me *reporter) removeApplied(cache_path string) {
+	if me != nil {
+		me.Lock()
+		delete(me.applied, cache_path)
+		me.Unlock()
+	}
+}
+
+func (me *reporter) setFailure(key string, err error) {
+	if me != nil {
+		me.Lock()
+		if err != nil {
+			me.failures[key] = err.Error()
+		} else {
+			delete(me.failures, key)
+		}
+		me.Unlock()
+	}
+}
+
+func (me *reporter) status() *Status {
+	me.Lock()
+	defer me.Unlock()
+
+	st := &Status{
+		Device: me.device,
+		Version: Version,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Applied: map[string]string{},
+		Failures: map[string]string{}}
+
+	for _, a := range me.applied {
+		st.Applied[a.key] = a.hash
+	}
+	for key, text := range me.failures {
+		st.Failures[key] = text
+	}
+
+	return st
+}
+
+// EnableStatusReport makes the forwarder publish its Status under
+// <prefix>/<device> after each SyncFiles and keep it alive with a TTL
+// heartbeat, so that the key disappears when the device goes away. The
+// prefix may be inside a watched namespace, whose keys under it are then
+// ignored, but it must not hold a whole namespace.
+func (me *ConFS) EnableStatusReport(prefix, device string, ttl time.Duration) error {
+	if me.report != nil {
+		return fmt.Errorf("status report is already enabled")
+	}
+	prefix = strings.TrimRight(prefix, "/")
+	if !strings.HasPrefix(prefix, "/") {
+		return fmt.Errorf("invalid status prefix '%s'", prefix)
+	}
+	for _, ns := range me.spaces {
+		if ns.Prefix == prefix || strings.HasPrefix(ns.Prefix, prefix + "/") {
+			return fmt.Errorf("status prefix '%s' contains the watched prefix '%s'", prefix, ns.Prefix)
+		}
+	}
+	if !isValidName(device) {
+		return fmt.Errorf("invalid device id '%s'", device)
+	}
+	if ttl < 3 * time.Second {
+		return fmt.Errorf("status TTL %v is too short", ttl)
+	}
+
+	me.report = &reporter{
+		prefix: prefix,
+		key: fmt.Sprintf("%s/%s", prefix, device),
+		device: device,
+		ttl: ttl,
+		applied: map[string]appliedFile{},
+		failures: map[string]string{}}
+
+	go me._heartbeat()
+
+	return nil
+}
+
+func (me *ConFS) _publish_status() error {
+	if me.report == nil {
+		return nil
+	}
+
+	content, err := json.Marshal(me.report.status())
+	if err != nil {
+		log.Printf("Failed to produce status: %v\n", err)
+		return err
+	}
+
+	opts := client.SetOptions{TTL: me.report.ttl}
+
+	if _, err := me.keys.Set(me.ctx, me.report.key, string(content), &opts); err != nil {
+		log.Printf("Failed to publish status to '%s': %v\n", me.report.key, err)
+		return err
+	}
+
+	return nil
+}
+
+func (me *ConFS) _heartbeat() {
+	ticker := time.NewTicker(me.report.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-me.ctx.Done():
+			return
+		case <-ticker.C:
+			opts := client.SetOptions{TTL: me.report.ttl, Refresh: true, PrevExist: client.PrevExist}
+
+			if _, err := me.keys.Set(me.ctx, me.report.key, "", &opts); err != nil {
+				me._publish_status()
+			}
+		}
+	}
+}
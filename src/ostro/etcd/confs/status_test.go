@@ -0,0 +1,56 @@
+package confs
+
+import (
+	"os"
+	"time"
+	"testing"
+)
+
+func TestStatusWriteDoesNotSync(t *testing.T) {
+	keys := newFakeKeys()
+	cfs := newTestConFS(t, keys, Namespace{"", ""})
+
+	if err := cfs.EnableStatusReport("/status", "device1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cfs.SyncFiles([]Change{keys.put("/app/conf", `{"a": 1}`)}, true); err != nil {
+		t.Fatal(err)
+	}
+	if sets := keys.setCalls(); len(sets) != 1 || sets[0] != "/status/device1" {
+		t.Fatalf("expected one status write, got %v", sets)
+	}
+
+	// the watcher of "/" sees the status just written and the one of
+	// another device
+	own := keys.values["/status/device1"]
+	changes := []Change{
+		{"set", own.Key, own.Value, own.ModifiedIndex},
+		keys.put("/status/device2", `{"device": "device2"}`)}
+	if err := cfs.SyncFiles(changes, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if sets := keys.setCalls(); len(sets) != 1 {
+		t.Fatalf("status write synced again: %v", sets)
+	}
+	for _, name := range []string{"device1", "device2"} {
+		if _, err := os.Stat(cfs.cache + "/status/" + name); !os.IsNotExist(err) {
+			t.Errorf("status of '%s' was copied to the cache: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(cfs.cache + "/app/conf"); err != nil {
+		t.Errorf("configuration was not copied: %v", err)
+	}
+}
+
+func TestStatusPrefixHoldingNamespace(t *testing.T) {
+	cfs := newTestConFS(t, newFakeKeys(), Namespace{"/fleet/defaults", ""}, Namespace{"/fleet/devices/device1", "local"})
+
+	if err := cfs.EnableStatusReport("/fleet", "device1", time.Minute); err == nil {
+		t.Errorf("status prefix holding the watched namespaces was accepted")
+	}
+	if err := cfs.EnableStatusReport("/fleet/status", "device1", time.Minute); err != nil {
+		t.Errorf("status prefix beside the namespaces was rejected: %v", err)
+	}
+}
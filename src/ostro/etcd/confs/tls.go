@@ -0,0 +1,285 @@
+package confs
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+	"strings"
+	"io/ioutil"
+	"net/http"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/coreos/etcd/client"
+)
+
+type Credentials struct {
+	CAFile string
+	CertFile string
+	KeyFile string
+	Username string
+	Password string
+	PasswordFile string
+	SyncEndpoints bool
+	AutoSyncInterval time.Duration
+}
+
+// tlsFiles keeps the CA bundle and the client key pair loaded and
+// re-reads them whenever a new connection is made after the files were
+// modified, so rotated certificates are picked up without a restart.
+type tlsFiles struct {
+	sync.Mutex
+	caFile string
+	certFile string
+	keyFile string
+	caTime time.Time
+	certTime time.Time
+	pool *x509.CertPool
+	cert *tls.Certificate
+}
+
+func modTime(files ...string) (time.Time, error) {
+	var latest time.Time
+
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+func (me *tlsFiles) certPool() (*x509.CertPool, error) {
+	me.Lock()
+	defer me.Unlock()
+
+	mtime, err := modTime(me.caFile)
+	if err != nil {
+		if me.pool != nil {
+			log.Printf("keep using CA bundle: %v\n", err)
+			return me.pool, nil
+		}
+		return nil, err
+	}
+
+	if me.pool == nil || mtime != me.caTime {
+		pem, err := ioutil.ReadFile(me.caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			if me.pool != nil {
+				log.Printf("keep using CA bundle: no certificate in '%s'\n", me.caFile)
+				return me.pool, nil
+			}
+			return nil, fmt.Errorf("no certificate in '%s'", me.caFile)
+		}
+		if me.pool != nil {
+			log.Printf("reloaded CA bundle '%s'\n", me.caFile)
+		}
+		me.pool = pool
+		me.caTime = mtime
+	}
+
+	return me.pool, nil
+}
+
+func (me *tlsFiles) keyPair() (*tls.Certificate, error) {
+	me.Lock()
+	defer me.Unlock()
+
+	mtime, err := modTime(me.certFile, me.keyFile)
+	if err != nil {
+		if me.cert != nil {
+			log.Printf("keep using client certificate: %v\n", err)
+			return me.cert, nil
+		}
+		return nil, err
+	}
+
+	if me.cert == nil || mtime != me.certTime {
+		cert, err := tls.LoadX509KeyPair(me.certFile, me.keyFile)
+		if err != nil {
+			if me.cert != nil {
+				log.Printf("keep using client certificate: %v\n", err)
+				return me.cert, nil
+			}
+			return nil, err
+		}
+		if me.cert != nil {
+			log.Printf("reloaded client certificate '%s'\n", me.certFile)
+		}
+		me.cert = &cert
+		me.certTime = mtime
+	}
+
+	return me.cert, nil
+}
+
+func (me *tlsFiles) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return me.keyPair()
+}
+
+// dialTLS connects with the standard verification of the host or IP that
+// was dialed, against the CA bundle as it is now.
+func (me *tlsFiles) dialTLS(dialer *net.Dialer, base *tls.Config, timeout time.Duration) func(network, addr string) (net.Conn, error) {
+	return func(network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := me.certPool()
+		if err != nil {
+			return nil, err
+		}
+
+		cfg := base.Clone()
+		cfg.RootCAs = pool
+		if cfg.ServerName == "" {
+			cfg.ServerName = host
+		}
+
+		conn, err := dialer.Dial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConn := tls.Client(conn, cfg)
+		conn.SetDeadline(time.Now().Add(timeout))
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn.SetDeadline(time.Time{})
+
+		return tlsConn, nil
+	}
+}
+
+func (me *Credentials) tlsConfig() (*tls.Config, *tlsFiles, error) {
+	if me.CAFile == "" && me.CertFile == "" && me.KeyFile == "" {
+		return nil, nil, nil
+	}
+	if (me.CertFile == "") != (me.KeyFile == "") {
+		return nil, nil, fmt.Errorf("client certificate and key must be given together")
+	}
+
+	files := &tlsFiles{caFile: me.CAFile, certFile: me.CertFile, keyFile: me.KeyFile}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if me.CAFile != "" {
+		pool, err := files.certPool()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load CA bundle: %v", err)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if me.CertFile != "" {
+		if _, err := files.keyPair(); err != nil {
+			return nil, nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		cfg.GetClientCertificate = files.getClientCertificate
+	}
+
+	return cfg, files, nil
+}
+
+// authTransport adds the basic authentication header itself instead of
+// leaving it to the etcd client, so that a changed password file is
+// picked up by the next request.
+type authTransport struct {
+	*http.Transport
+	sync.Mutex
+	username string
+	creds *Credentials
+	password string
+	mtime time.Time
+}
+
+func (me *authTransport) currentPassword() (string, error) {
+	if me.creds.PasswordFile == "" {
+		return me.creds.Password, nil
+	}
+
+	me.Lock()
+	defer me.Unlock()
+
+	mtime, err := modTime(me.creds.PasswordFile)
+	if err != nil {
+		return "", err
+	}
+
+	if mtime != me.mtime {
+		content, err := ioutil.ReadFile(me.creds.PasswordFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read password: %v", err)
+		}
+		me.password = strings.TrimRight(string(content), "\r\n")
+		me.mtime = mtime
+	}
+
+	return me.password, nil
+}
+
+func (me *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	password, err := me.currentPassword()
+	if err != nil {
+		return nil, err
+	}
+
+	authReq := new(http.Request)
+	*authReq = *req
+	authReq.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		authReq.Header[k] = v
+	}
+	authReq.SetBasicAuth(me.username, password)
+
+	return me.Transport.RoundTrip(authReq)
+}
+
+func (me *Credentials) clientConfig(servers []string) (client.Config, error) {
+	cfg := client.Config{Endpoints: servers}
+
+	if me == nil {
+		return cfg, nil
+	}
+
+	tlsCfg, files, err := me.tlsConfig()
+	if err != nil {
+		return cfg, err
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		Dial: dialer.Dial,
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSClientConfig: tlsCfg}
+
+	if me.CAFile != "" {
+		// a CA bundle replaced since the last connection is used for the next
+		transport.DialTLS = files.dialTLS(dialer, tlsCfg, transport.TLSHandshakeTimeout)
+	}
+
+	if me.Username == "" {
+		cfg.Transport = transport
+	} else {
+		auth := &authTransport{Transport: transport, username: me.Username, creds: me}
+		if _, err := auth.currentPassword(); err != nil {
+			return cfg, err
+		}
+		cfg.Transport = auth
+	}
+
+	return cfg, nil
+}
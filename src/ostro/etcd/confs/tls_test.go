@@ -0,0 +1,150 @@
+package confs
+
+import (
+	"os"
+	"net"
+	"time"
+	"testing"
+	"math/big"
+	"io/ioutil"
+	"path/filepath"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/rand"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509/pkix"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key *ecdsa.PrivateKey
+	pem []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{CommonName: "test CA"},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().Add(time.Hour),
+		IsCA: true,
+		BasicConstraintsValid: true,
+		KeyUsage: x509.KeyUsageCertSign}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a server certificate for the addresses.
+func (me *testCA) issue(t *testing.T, ips ...net.IP) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject: pkix.Name{CommonName: "etcd"},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().Add(time.Hour),
+		IPAddresses: ips,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage: x509.KeyUsageDigitalSignature}
+	der, err := x509.CreateCertificate(rand.Reader, template, me.cert, &key.PublicKey, me.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func newTLSServer(t *testing.T, cert tls.Certificate) *httptest.Server {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func tlsGet(t *testing.T, creds *Credentials, url string) error {
+	cfg, err := creds.clientConfig([]string{url})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := (&http.Client{Transport: cfg.Transport}).Get(url)
+	if err == nil {
+		resp.Body.Close()
+	}
+	return err
+}
+
+func TestTLSVerifiesDialedAddress(t *testing.T) {
+	ca := newTestCA(t)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := ioutil.WriteFile(caFile, ca.pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	creds := &Credentials{CAFile: caFile}
+
+	good := newTLSServer(t, ca.issue(t, net.ParseIP("127.0.0.1")))
+	if err := tlsGet(t, creds, good.URL); err != nil {
+		t.Errorf("certificate for the dialed IP rejected: %v", err)
+	}
+
+	other := newTLSServer(t, ca.issue(t, net.ParseIP("10.1.2.3")))
+	if err := tlsGet(t, creds, other.URL); err == nil {
+		t.Errorf("certificate for another IP accepted")
+	}
+}
+
+func TestTLSReloadsCABundle(t *testing.T) {
+	oldCA, newCA := newTestCA(t), newTestCA(t)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := ioutil.WriteFile(caFile, oldCA.pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := (&Credentials{CAFile: caFile}).clientConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: cfg.Transport}
+
+	srv := newTLSServer(t, newCA.issue(t, net.ParseIP("127.0.0.1")))
+	if resp, err := client.Get(srv.URL); err == nil {
+		resp.Body.Close()
+		t.Fatalf("certificate of an unknown CA accepted")
+	}
+
+	if err := ioutil.WriteFile(caFile, newCA.pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(caFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("replaced CA bundle not used: %v", err)
+	}
+	resp.Body.Close()
+}
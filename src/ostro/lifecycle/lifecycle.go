@@ -0,0 +1,225 @@
+package lifecycle
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"flag"
+	"sync"
+	"time"
+	"bufio"
+	"context"
+	"strings"
+	"syscall"
+	"os/signal"
+)
+
+var (
+	configFile   = ""
+	drainTimeout = 10 * time.Second
+
+	ctx, cancel  = context.WithCancel(context.Background())
+
+	mutex        sync.Mutex
+	started      = false
+	stopping     = false
+	pending      sync.WaitGroup
+	reloadHooks  []func()
+	shutdownHooks []func()
+	cmdLineFlags = map[string]bool{}
+)
+
+// AddFlags registers -config and -drain-timeout; the mains call it before
+// flag.Parse().
+func AddFlags() {
+	flag.StringVar(&configFile, "config", configFile, "file of 'flag = value' lines applied on startup and re-applied on SIGHUP; command line flags take precedence")
+	flag.DurationVar(&drainTimeout, "drain-timeout", drainTimeout, "time to wait for pending drop zone writes and requests on shutdown")
+}
+
+// Start has to be called after flag.Parse(). It applies the config file and
+// installs the handlers of SIGTERM, SIGINT and SIGHUP. The returned context
+// is cancelled when the daemon is asked to terminate.
+func Start() context.Context {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if started {
+		return ctx
+	}
+	started = true
+
+	flag.Visit(func(f *flag.Flag) {
+		cmdLineFlags[f.Name] = true
+	})
+
+	if err := loadConfig(); err != nil {
+		log.Printf("%v\n", err)
+	}
+
+	sigchan := make(chan os.Signal, 4)
+	signal.Notify(sigchan, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+
+	go func() {
+		for sig := range sigchan {
+			switch sig {
+			case syscall.SIGHUP:
+				reload()
+			default:
+				log.Printf("received %v: shutting down\n", sig)
+				cancel()
+			}
+		}
+	}()
+
+	return ctx
+}
+
+func Context() context.Context {
+	return ctx
+}
+
+func Done() <-chan struct{} {
+	return ctx.Done()
+}
+
+func Stop() {
+	cancel()
+}
+
+// OnReload registers a function that is called on SIGHUP, after the config
+// file has been re-applied. The hooks carry the new flag values over to
+// what is running and re-read what they own, like credential files, under
+// their own locks.
+func OnReload(hook func()) {
+	mutex.Lock()
+	reloadHooks = append(reloadHooks, hook)
+	mutex.Unlock()
+}
+
+// OnShutdown registers a function that is called when the daemon
+// terminates, after the pending writes are finished. Hooks are called in
+// the reverse order of their registration.
+func OnShutdown(hook func()) {
+	mutex.Lock()
+	shutdownHooks = append(shutdownHooks, hook)
+	mutex.Unlock()
+}
+
+// Begin marks the start of a write that must not be interrupted by the
+// shutdown. It returns false once the shutdown has begun.
+func Begin() bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if stopping {
+		return false
+	}
+	pending.Add(1)
+
+	return true
+}
+
+func End() {
+	pending.Done()
+}
+
+// Wait blocks until the context is cancelled, then waits for the pending
+// writes and runs the shutdown hooks.
+func Wait() {
+	<-ctx.Done()
+	shutdown()
+}
+
+func Exit(code int) {
+	cancel()
+	shutdown()
+	os.Exit(code)
+}
+
+func Fatalf(format string, args ...interface{}) {
+	log.Printf(format, args...)
+	Exit(1)
+}
+
+func shutdown() {
+	mutex.Lock()
+	stopping = true
+	hooks := shutdownHooks
+	shutdownHooks = nil
+	mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(drainTimeout):
+		log.Printf("gave up waiting for pending writes after %v\n", drainTimeout)
+	}
+
+	for i := len(hooks) - 1;  i >= 0;  i-- {
+		hooks[i]()
+	}
+}
+
+func reload() {
+	log.Printf("received SIGHUP: reloading configuration\n")
+
+	if err := loadConfig(); err != nil {
+		log.Printf("%v\n", err)
+	}
+
+	mutex.Lock()
+	hooks := reloadHooks
+	mutex.Unlock()
+
+	for _, hook := range hooks {
+		hook()
+	}
+}
+
+func loadConfig() error {
+	if configFile == "" {
+		return nil
+	}
+
+	file, err := os.Open(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	for lineno := 1;  scanner.Scan();  lineno++ {
+		line := strings.Trim(scanner.Text(), " \t\r")
+
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		name := strings.TrimLeft(strings.Trim(kv[0], " \t"), "-")
+		value := "true"
+		if len(kv) > 1 {
+			value = strings.Trim(kv[1], " \t")
+		}
+
+		if name == "config" || cmdLineFlags[name] {
+			continue
+		}
+		// unchanged flags are left alone, for the readers of the running daemon
+		if f := flag.Lookup(name); f != nil && f.Value.String() == value {
+			continue
+		}
+
+		if err := flag.Set(name, value); err != nil {
+			log.Printf("%s:%d: %v\n", configFile, lineno, err)
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,44 @@
+package lifecycle
+
+import (
+	"flag"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+)
+
+func TestReloadAppliesConfig(t *testing.T) {
+	level := flag.Int("test-level", 1, "")
+	pinned := flag.String("test-pinned", "cmdline", "")
+	cmdLineFlags["test-pinned"] = true
+
+	configFile = filepath.Join(t.TempDir(), "config")
+	defer func() {
+		configFile = ""
+	}()
+
+	seen := []int{}
+	OnReload(func() {
+		seen = append(seen, *level)
+	})
+
+	for _, c := range []struct{
+		content string
+		level int
+	}{
+		{"test-level = 2\n--test-pinned = config\n", 2},
+		{"# comment\ntest-level=3\n", 3},
+	} {
+		if err := ioutil.WriteFile(configFile, []byte(c.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		reload()
+
+		if *level != c.level || seen[len(seen)-1] != c.level {
+			t.Errorf("level %d, hook saw %v, expected %d", *level, seen, c.level)
+		}
+		if *pinned != "cmdline" {
+			t.Errorf("command line flag overridden with '%s'", *pinned)
+		}
+	}
+}
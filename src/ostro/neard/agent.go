@@ -3,6 +3,7 @@ package neard
 import (
 	"github.com/godbus/dbus"
 	"ostro/confs"
+	"ostro/lifecycle"
 )
 
 const (
@@ -53,6 +54,10 @@ func newAgent(srv *server, tagType []string) bool {
 		}
 		
 		agnt = &agent{tagType: map[string]bool{}, handover: nil, ndef: nil}
+
+		lifecycle.OnShutdown(func() {
+			agnt.release(srv)
+		})
 	}
 
 	for _, t := range tagType {
@@ -87,6 +92,32 @@ func newAgent(srv *server, tagType []string) bool {
 	return true
 }
 
+func (me *agent) release(srv *server) {
+	objPath := dbus.ObjectPath(agentPath)
+
+	if me.handover != nil {
+		for carrier := range me.handover.carriers {
+			if err := srv.neardObject.Call(UnregisterHandoverAgent, 0, objPath, carrier).Err; err != nil {
+				confs.Errorf("can't unregister Handover carrier '%s': %v", carrier, err)
+			}
+		}
+	}
+
+	if me.ndef != nil {
+		for t := range me.ndef.types {
+			if err := srv.neardObject.Call(UnregisterNDEFAgent, 0, objPath, t).Err; err != nil {
+				confs.Errorf("can't unregister NDEF type '%s': %v", t, err)
+			}
+		}
+	}
+
+	if _, err := srv.conn.ReleaseName(agentService); err != nil {
+		confs.Errorf("Failed to release D-Bus name '%s': %v", agentService, err)
+	} else {
+		confs.Debugf("D-Bus name '%s' released", agentService)
+	}
+}
+
 func newHandoverAgent(srv *server) *handoverAgent {
 	a := handoverAgent{srv: srv, carriers: map[string]bool{}}
 
@@ -2,11 +2,11 @@ package neard
 
 import (
 	"fmt"
-	"os"
 	"path"
 	"github.com/godbus/dbus"
 	"github.com/godbus/dbus/introspect"
 	"ostro/confs"
+	"ostro/lifecycle"
 )
 
 const (
@@ -150,7 +150,7 @@ func (me *server) connect() {
 	if me.getAdapters() {
 		if !newAgent(me, me.events) {
 			confs.Errorf("can't recover from errors: giving up ...")
-			os.Exit(1)
+			lifecycle.Exit(1)
 		}
 	}
 }
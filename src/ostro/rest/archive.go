@@ -0,0 +1,218 @@
+package rest
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"io"
+	"bytes"
+	"bufio"
+	"strconv"
+	"strings"
+	"net/http"
+	"path/filepath"
+	"compress/gzip"
+	"encoding/json"
+	"ostro/confs"
+	"ostro/tar"
+)
+
+const (
+	maxArchiveSize = 16 * 1024 * 1024
+)
+
+type importReport struct {
+	DryRun bool `json:"dryRun"`
+	Failed int `json:"failed"`
+	Files []tar.Result `json:"files"`
+}
+
+func (me *FileHandler) exportHandler(w http.ResponseWriter, r *http.Request) {
+	if me.cors.Handle(w, r) {
+		return
+	}
+
+	if r.Method == "OPTIONS" {
+		http.Error(w, "OK", http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, fmt.Sprintf("'%s' method not supported", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	confPath := "/" + me.subtree
+	compress := false
+
+	for name, values := range r.URL.Query() {
+		switch name {
+		case "path":
+			confPath = filepath.Clean("/" + values[0])
+		case "compress":
+			if values[0] != "gzip" {
+				http.Error(w, fmt.Sprintf("unsupported compression '%s'", values[0]), http.StatusBadRequest)
+				return
+			}
+			compress = true
+		default:
+			http.Error(w, fmt.Sprintf("unknown query parameter '%s'", name), http.StatusBadRequest)
+			return
+		}
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(confPath, "/"), "/", 2)
+	if !confs.IsValidPath(confPath) || !isLayer(parts[0]) {
+		http.Error(w, fmt.Sprintf("invalid path '%s'", confPath), http.StatusBadRequest)
+		return
+	}
+
+	id, ok := me.authenticateRequest(w, r)
+	if !ok {
+		return
+	}
+	if id != nil && !me.auth.permits(id, confPath, false) {
+		log.Printf("REST access denied for '%s' to export %s\n", id.User, confPath)
+		http.Error(w, "access denied", http.StatusForbidden)
+		return
+	}
+
+	// the tree must be there, and stay inside the drop zone through links
+	treePath, err := filepath.EvalSymlinks(me.root + confPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, fmt.Sprintf("'%s' not found", confPath), http.StatusNotFound)
+		} else {
+			http.Error(w, fmt.Sprintf("can't export '%s': %v", confPath, err), http.StatusInternalServerError)
+		}
+		return
+	}
+	if root, err := filepath.EvalSymlinks(me.root); err != nil || (treePath != root && !strings.HasPrefix(treePath, root + "/")) {
+		http.Error(w, fmt.Sprintf("'%s' is outside of the drop zone", confPath), http.StatusBadRequest)
+		return
+	}
+
+	// files under rules denying reads are left out
+	accept := func(path string) bool {
+		return id == nil || me.auth.permits(id, path, false)
+	}
+
+	// the archive is built before the status goes out, so a failure is
+	// not sent as a truncated archive
+	var archive bytes.Buffer
+	if compress {
+		gz := gzip.NewWriter(&archive)
+		if err = tar.Export(gz, me.root, confPath, accept); err == nil {
+			err = gz.Close()
+		}
+	} else {
+		err = tar.Export(&archive, me.root, confPath, accept)
+	}
+	if err != nil {
+		log.Printf("exporting '%s' failed: %v\n", confPath, err)
+		http.Error(w, fmt.Sprintf("exporting '%s' failed: %v", confPath, err), http.StatusInternalServerError)
+		return
+	}
+
+	name := "confs.tar"
+	if compress {
+		name += ".gz"
+		w.Header().Set("Content-Type", "application/gzip")
+	} else {
+		w.Header().Set("Content-Type", "application/x-tar")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", name))
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", archive.Len()))
+
+	archive.WriteTo(w)
+}
+
+func (me *FileHandler) importHandler(w http.ResponseWriter, r *http.Request) {
+	if me.cors.Handle(w, r) {
+		return
+	}
+
+	if r.Method == "OPTIONS" {
+		http.Error(w, "OK", http.StatusOK)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, fmt.Sprintf("'%s' method not supported", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	subtree := "/" + me.subtree
+	opts := tar.ExtractOptions{Prefix: ".", Origin: RestOriginated}
+
+	for name, values := range r.URL.Query() {
+		switch name {
+		case "dry-run":
+			on, err := strconv.ParseBool(values[0])
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid dry-run '%s'", values[0]), http.StatusBadRequest)
+				return
+			}
+			opts.DryRun = on
+		case "prefix":
+			opts.Prefix = values[0]
+		default:
+			http.Error(w, fmt.Sprintf("unknown query parameter '%s'", name), http.StatusBadRequest)
+			return
+		}
+	}
+
+	id, ok := me.authenticateRequest(w, r)
+	if !ok {
+		return
+	}
+
+	opts.Accept = func(confPath string) error {
+		if confPath != subtree && !strings.HasPrefix(confPath, subtree + "/") {
+			return fmt.Errorf("outside of '%s'", subtree)
+		}
+		if id != nil && !me.auth.permits(id, confPath, true) {
+			log.Printf("REST access denied for '%s' to import %s\n", id.User, confPath)
+			return fmt.Errorf("access denied")
+		}
+		return nil
+	}
+
+	me.writeLock.Lock()
+	defer me.writeLock.Unlock()
+
+	body := bufio.NewReader(http.MaxBytesReader(w, r.Body, maxArchiveSize))
+
+	var archive io.Reader = body
+	if magic, err := body.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("malformed archive: %v", err), http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		archive = gz
+	}
+
+	results, err := tar.Extract(archive, opts)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("malformed archive: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	report := importReport{DryRun: opts.DryRun, Files: results}
+	for _, res := range results {
+		if res.Status == tar.Failed {
+			report.Failed++
+		}
+	}
+
+	reply, err := json.MarshalIndent(report, "", "    ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(reply)))
+
+	w.Write(reply)
+}
@@ -0,0 +1,72 @@
+package rest
+
+import (
+	"os"
+	"io"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+	"archive/tar"
+	"net/http"
+	"net/http/httptest"
+)
+
+func TestExportPath(t *testing.T) {
+	root := testRoot(t)
+
+	handler, err := NewHandler(Config{Root: root, Prefix: "/confs/local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "local", "net"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "local", "net", "wifi"), []byte(`{"ssid": "home"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "local", "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct{
+		query string
+		status int
+	}{
+		{"?path=/local/net", http.StatusOK},
+		{"", http.StatusOK},
+		{"?path=/local/missing", http.StatusNotFound},
+		{"?path=/local/escape", http.StatusBadRequest},
+		{"?path=/other", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/confs/export" + c.query, nil))
+
+		if w.Code != c.status {
+			t.Errorf("export %s: got %d, expected %d", c.query, w.Code, c.status)
+			continue
+		}
+		if c.status != http.StatusOK {
+			if typ := w.Header().Get("Content-Type"); typ == "application/x-tar" {
+				t.Errorf("export %s failed as an archive", c.query)
+			}
+			continue
+		}
+
+		names := []string{}
+		reader := tar.NewReader(w.Body)
+		for {
+			header, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("export %s: %v", c.query, err)
+			}
+			names = append(names, header.Name)
+		}
+		if len(names) != 1 || names[0] != "./local/net/wifi" {
+			t.Errorf("export %s: got %v", c.query, names)
+		}
+	}
+}
@@ -0,0 +1,488 @@
+package rest
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"bufio"
+	"errors"
+	"strings"
+	"net/http"
+	"io/ioutil"
+	"crypto/x509"
+	"crypto/subtle"
+	"ostro/lifecycle"
+	"ostro/session"
+)
+
+const (
+	authRealm = "confs"
+)
+
+var (
+	credentialError = errors.New("invalid credentials")
+	unknownUserError = errors.New("unknown user")
+)
+
+type AuthConfig struct {
+	TokenFile string		// lines of '<token> <user> <role>[,<role>...]'
+	PasswordFile string		// lines of '<user>:<hash>:<role>[,<role>...]', see session.Users
+	ClientCAFile string		// CA bundle of the accepted client certificates
+	RulesFile string		// lines of '<path> <read roles> <write roles>'
+}
+
+type Identity struct {
+	User string
+	Roles []string
+}
+
+// Authenticator finds the identity of the request. It returns nil and no
+// error when the request carries no credentials of its kind.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+	Challenge() string
+}
+
+type accessRule struct {
+	path string
+	read map[string]bool
+	write map[string]bool
+}
+
+type Auth struct {
+	sync.RWMutex
+	config AuthConfig
+	authenticators []Authenticator
+	users *session.Users
+	rules []accessRule
+	clientCAs *x509.CertPool
+}
+
+func NewAuth(config AuthConfig) (*Auth, error) {
+	me := &Auth{config: config}
+
+	if err := me.load(); err != nil {
+		return nil, err
+	}
+
+	lifecycle.OnReload(func() {
+		if err := me.load(); err != nil {
+			log.Printf("keep using the previous REST credentials: %v\n", err)
+		} else {
+			log.Printf("reloaded REST credentials\n")
+		}
+	})
+
+	return me, nil
+}
+
+// AddAuthenticator appends an authentication method that is tried after
+// the configured ones.
+func (me *Auth) AddAuthenticator(a Authenticator) {
+	me.Lock()
+	me.authenticators = append(me.authenticators, a)
+	me.Unlock()
+}
+
+func (me *Auth) load() error {
+	var (
+		authenticators []Authenticator
+		rules []accessRule
+		clientCAs *x509.CertPool
+	)
+
+	var users *session.Users
+
+	if me.config.PasswordFile != "" {
+		var err error
+		if users, err = session.ReadUsers(me.config.PasswordFile); err != nil {
+			return err
+		}
+		authenticators = append(authenticators, &basicAuthenticator{users: users})
+	}
+
+	if me.config.TokenFile != "" {
+		tokens, err := readTokens(me.config.TokenFile)
+		if err != nil {
+			return err
+		}
+		authenticators = append(authenticators, &tokenAuthenticator{tokens: tokens})
+	}
+
+	if me.config.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(me.config.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA bundle: %v", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate in '%s'", me.config.ClientCAFile)
+		}
+		authenticators = append(authenticators, &certAuthenticator{users: users})
+	}
+
+	if me.config.RulesFile != "" {
+		var err error
+		if rules, err = readRules(me.config.RulesFile); err != nil {
+			return err
+		}
+	}
+
+	me.Lock()
+	defer me.Unlock()
+
+	// authenticators added by AddAuthenticator survive the reload
+	for _, a := range me.authenticators {
+		switch a.(type) {
+		case *basicAuthenticator, *tokenAuthenticator, *certAuthenticator:
+		default:
+			authenticators = append(authenticators, a)
+		}
+	}
+
+	me.authenticators = authenticators
+	me.users = users
+	me.rules = rules
+	me.clientCAs = clientCAs
+
+	return nil
+}
+
+// ClientCAs is the current CA bundle of the client certificates, re-read
+// on SIGHUP; the servers consult it at every handshake.
+func (me *Auth) ClientCAs() *x509.CertPool {
+	me.RLock()
+	defer me.RUnlock()
+
+	return me.clientCAs
+}
+
+// authenticate tries the authenticators in order; the first one finding
+// credentials of its kind decides.
+func (me *Auth) authenticate(r *http.Request) (*Identity, error) {
+	me.RLock()
+	authenticators := me.authenticators
+	me.RUnlock()
+
+	for _, a := range authenticators {
+		id, err := a.Authenticate(r)
+		if err != nil || id != nil {
+			return id, err
+		}
+	}
+
+	return nil, nil
+}
+
+func (me *Auth) challenges() []string {
+	me.RLock()
+	defer me.RUnlock()
+
+	challenges := []string{}
+	for _, a := range me.authenticators {
+		if c := a.Challenge(); c != "" {
+			challenges = append(challenges, c)
+		}
+	}
+
+	return challenges
+}
+
+// permits checks the roles of the identity against the rule with the
+// longest path matching the request. Without rules every authenticated
+// user has full access; with rules anything not covered is denied.
+func (me *Auth) permits(id *Identity, path string, write bool) bool {
+	me.RLock()
+	defer me.RUnlock()
+
+	if me.rules == nil {
+		return true
+	}
+
+	var match *accessRule
+	for i, rule := range me.rules {
+		if rule.path == "/" || path == rule.path || strings.HasPrefix(path, rule.path + "/") {
+			if match == nil || len(rule.path) > len(match.path) {
+				match = &me.rules[i]
+			}
+		}
+	}
+	if match == nil {
+		return false
+	}
+
+	roles := match.read
+	if write {
+		roles = match.write
+	}
+	for _, role := range id.Roles {
+		if roles[role] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// permitsTree is permits for recursive requests, like reading or removing
+// a directory: the rules of the paths under the path have to permit too.
+func (me *Auth) permitsTree(id *Identity, path string, write bool) bool {
+	if !me.permits(id, path, write) {
+		return false
+	}
+
+	me.RLock()
+	defer me.RUnlock()
+
+	for _, rule := range me.rules {
+		if rule.path == path || path != "/" && !strings.HasPrefix(rule.path, path + "/") {
+			continue
+		}
+		roles := rule.read
+		if write {
+			roles = rule.write
+		}
+		permitted := false
+		for _, role := range id.Roles {
+			permitted = permitted || roles[role]
+		}
+		if !permitted {
+			return false
+		}
+	}
+
+	return true
+}
+
+// authenticateRequest answers 401 unless the request has valid
+// credentials. The identity is nil when authentication is disabled.
+func (me *FileHandler) authenticateRequest(w http.ResponseWriter, r *http.Request) (*Identity, bool) {
+	auth := me.auth
+	if auth == nil || isLocal(r) {
+		return nil, true
+	}
+
+	id, err := auth.authenticate(r)
+	if err != nil || id == nil {
+		if err == nil {
+			err = fmt.Errorf("no credentials")
+		}
+		log.Printf("REST authentication failed for %s %s from %s: %v\n", r.Method, r.URL.Path, r.RemoteAddr, err)
+		if errors.Is(err, session.CSRFError) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return nil, false
+		}
+		for _, c := range auth.challenges() {
+			w.Header().Add("WWW-Authenticate", c)
+		}
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	return id, true
+}
+
+// authorize answers 401 or 403 unless the request may access the path and
+// everything under it.
+func (me *FileHandler) authorize(path string, w http.ResponseWriter, r *http.Request) bool {
+	auth := me.auth
+	if auth == nil || isLocal(r) {
+		return true
+	}
+
+	id, ok := me.authenticateRequest(w, r)
+	if !ok {
+		return false
+	}
+
+	write := r.Method != "GET" && r.Method != "HEAD" && r.Method != "OPTIONS"
+
+	if !auth.permitsTree(id, path, write) {
+		log.Printf("REST access denied for '%s' to %s %s\n", id.User, r.Method, path)
+		http.Error(w, "access denied", http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+type tokenAuthenticator struct {
+	tokens map[string]Identity
+}
+
+func (me *tokenAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return nil, nil
+	}
+
+	presented := []byte(strings.TrimSpace(header[7:]))
+
+	// compare all the tokens in constant time to leak nothing about them
+	var found *Identity
+	for token, id := range me.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), presented) == 1 {
+			identity := id
+			found = &identity
+		}
+	}
+	if found == nil {
+		return nil, credentialError
+	}
+
+	return found, nil
+}
+
+func (me *tokenAuthenticator) Challenge() string {
+	return fmt.Sprintf("Bearer realm=\"%s\"", authRealm)
+}
+
+type basicAuthenticator struct {
+	users *session.Users
+}
+
+func (me *basicAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+
+	roles, err := me.users.Verify(user, password)
+	if err != nil {
+		return nil, credentialError
+	}
+
+	return &Identity{User: user, Roles: roles}, nil
+}
+
+func (me *basicAuthenticator) Challenge() string {
+	return fmt.Sprintf("Basic realm=\"%s\"", authRealm)
+}
+
+// sessionAuthenticator accepts the sessions of the users logged in to the
+// UI. State changing requests have to carry the CSRF token of the session,
+// as browsers send the cookie with any request.
+type sessionAuthenticator struct {
+	sessions *session.Manager
+}
+
+func SessionAuthenticator(sessions *session.Manager) Authenticator {
+	return &sessionAuthenticator{sessions: sessions}
+}
+
+func (me *sessionAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	s := me.sessions.Lookup(r)
+	if s == nil {
+		return nil, nil
+	}
+
+	if r.Method != "GET" && r.Method != "HEAD" && r.Method != "OPTIONS" {
+		if err := session.CheckCSRF(r, s); err != nil {
+			return nil, fmt.Errorf("session of '%s': %w", s.User, err)
+		}
+	}
+
+	return &Identity{User: s.User, Roles: s.Roles}, nil
+}
+
+func (me *sessionAuthenticator) Challenge() string {
+	return ""
+}
+
+// certAuthenticator accepts the client certificates verified by the TLS
+// layer. The common name is the user; its roles come from the password
+// file, where a '!' hash allows certificate logins only.
+type certAuthenticator struct {
+	users *session.Users
+}
+
+func (me *certAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, nil
+	}
+
+	user := r.TLS.VerifiedChains[0][0].Subject.CommonName
+
+	var roles []string
+	found := false
+	if me.users != nil {
+		roles, found = me.users.Roles(user)
+	}
+	if !found {
+		return nil, fmt.Errorf("%v: certificate '%s'", unknownUserError, user)
+	}
+
+	return &Identity{User: user, Roles: roles}, nil
+}
+
+func (me *certAuthenticator) Challenge() string {
+	return ""
+}
+
+func readLines(path string, handle func(lineno int, fields []string) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	for lineno := 1;  scanner.Scan();  lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if err := handle(lineno, strings.Fields(line)); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, lineno, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+func splitRoles(list string) []string {
+	return session.SplitRoles(list)
+}
+
+func readTokens(path string) (map[string]Identity, error) {
+	tokens := map[string]Identity{}
+
+	err := readLines(path, func(lineno int, fields []string) error {
+		if len(fields) != 3 {
+			return fmt.Errorf("expected '<token> <user> <roles>'")
+		}
+		tokens[fields[0]] = Identity{User: fields[1], Roles: splitRoles(fields[2])}
+		return nil
+	})
+
+	return tokens, err
+}
+
+func readRules(path string) ([]accessRule, error) {
+	rules := []accessRule{}
+
+	err := readLines(path, func(lineno int, fields []string) error {
+		if len(fields) != 3 || fields[0][0] != '/' {
+			return fmt.Errorf("expected '<path> <read roles> <write roles>'")
+		}
+		rule := accessRule{
+			path: strings.TrimRight(fields[0], "/"),
+			read: map[string]bool{},
+			write: map[string]bool{}}
+		if rule.path == "" {
+			rule.path = "/"
+		}
+		for _, role := range splitRoles(fields[1]) {
+			rule.read[role] = true
+		}
+		for _, role := range splitRoles(fields[2]) {
+			rule.write[role] = true
+		}
+		rules = append(rules, rule)
+		return nil
+	})
+
+	return rules, err
+}
@@ -0,0 +1,62 @@
+package rest
+
+import (
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+	"net/http"
+	"net/http/httptest"
+)
+
+func newTestAuth(t *testing.T, rules string) *Auth {
+	dir := t.TempDir()
+
+	tokens := filepath.Join(dir, "tokens")
+	if err := ioutil.WriteFile(tokens, []byte("admin-token alice admin\nuser-token bob user\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	rulesFile := filepath.Join(dir, "rules")
+	if err := ioutil.WriteFile(rulesFile, []byte(rules), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	auth, err := NewAuth(AuthConfig{TokenFile: tokens, RulesFile: rulesFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return auth
+}
+
+func authorizeStatus(handler *FileHandler, method, path, token string) int {
+	r := httptest.NewRequest(method, "/confs" + path, nil)
+	r.Header.Set("Authorization", "Bearer " + token)
+	w := httptest.NewRecorder()
+
+	if handler.authorize(path, w, r) {
+		return http.StatusOK
+	}
+	return w.Code
+}
+
+func TestAuthorizeChildRule(t *testing.T) {
+	handler := &FileHandler{auth: newTestAuth(t, "/local admin,user admin,user\n/local/secret admin admin\n")}
+
+	for _, c := range []struct{
+		method, path, token string
+		status int
+	}{
+		{"DELETE", "/local", "user-token", http.StatusForbidden},
+		{"GET", "/local", "user-token", http.StatusForbidden},
+		{"DELETE", "/local/secret", "user-token", http.StatusForbidden},
+		{"DELETE", "/local/public", "user-token", http.StatusOK},
+		{"GET", "/local/public", "user-token", http.StatusOK},
+		{"DELETE", "/local", "admin-token", http.StatusOK},
+		{"GET", "/local/secret", "admin-token", http.StatusOK},
+		{"GET", "/local", "no-token", http.StatusUnauthorized},
+	} {
+		if status := authorizeStatus(handler, c.method, c.path, c.token); status != c.status {
+			t.Errorf("%s %s with %s: got %d, expected %d", c.method, c.path, c.token, status, c.status)
+		}
+	}
+}
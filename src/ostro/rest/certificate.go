@@ -0,0 +1,39 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"encoding/json"
+)
+
+// certificateHandler describes the self-signed TLS certificate, so that
+// clients can pin its fingerprint or public key. It is public, like the
+// certificate itself.
+func (me *FileHandler) certificateHandler(w http.ResponseWriter, r *http.Request) {
+	if me.cors.Handle(w, r) {
+		return
+	}
+
+	if r.Method == "OPTIONS" {
+		http.Error(w, "OK", http.StatusOK)
+		return
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, fmt.Sprintf("'%s' method not supported", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	reply, err := json.MarshalIndent(me.certificate.Info(), "", "    ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(reply)))
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if r.Method == "GET" {
+		w.Write(reply)
+	}
+}
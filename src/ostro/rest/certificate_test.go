@@ -0,0 +1,140 @@
+package rest
+
+import (
+	"time"
+	"testing"
+	"math/big"
+	"io/ioutil"
+	"path/filepath"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/rand"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509/pkix"
+)
+
+// issueCert returns a certificate signed by parent, self-signed if nil,
+// with its key and PEM.
+func issueCert(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, usage x509.ExtKeyUsage) (*x509.Certificate, *ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject: pkix.Name{CommonName: name},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().Add(time.Hour),
+		DNSNames: []string{name}}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	} else {
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		template.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func writeKeyPair(t *testing.T, dir string, certPEM []byte, key *ecdsa.PrivateKey) (string, string) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestClientCAReload(t *testing.T) {
+	dir := t.TempDir()
+
+	oldCA, oldKey, oldPEM := issueCert(t, "old CA", nil, nil, 0)
+	newCA, newKey, newPEM := issueCert(t, "new CA", nil, nil, 0)
+	_, serverKey, serverPEM := issueCert(t, "device", oldCA, oldKey, x509.ExtKeyUsageServerAuth)
+	_, clientKey, clientPEM := issueCert(t, "alice", newCA, newKey, x509.ExtKeyUsageClientAuth)
+
+	caFile := filepath.Join(dir, "clients.pem")
+	if err := ioutil.WriteFile(caFile, oldPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	auth, err := NewAuth(AuthConfig{ClientCAFile: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := writeKeyPair(t, dir, serverPEM, serverKey)
+	cfg, err := (&FileHandler{auth: auth}).serverTLSConfig(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			http.Error(w, "no client certificate", http.StatusUnauthorized)
+		}
+	}))
+	srv.TLS = cfg
+	srv.StartTLS()
+	defer srv.Close()
+
+	clientCert, clientKeyFile := writeKeyPair(t, t.TempDir(), clientPEM, clientKey)
+	pair, err := tls.LoadX509KeyPair(clientCert, clientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func() (int, error) {
+		// a new connection for every request, each with its handshake, and
+		// the certificate sent even if its CA is not among the accepted ones
+		client := &http.Client{Transport: &http.Transport{
+			DisableKeepAlives: true,
+			TLSClientConfig: &tls.Config{
+				GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+					return &pair, nil
+				},
+				InsecureSkipVerify: true}}}
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	if status, err := get(); err == nil {
+		t.Errorf("certificate of an unknown CA accepted with %d", status)
+	}
+
+	if err := ioutil.WriteFile(caFile, newPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.load(); err != nil {
+		t.Fatal(err)
+	}
+
+	if status, err := get(); err != nil || status != http.StatusOK {
+		t.Errorf("certificate of the reloaded CA: got %d, %v", status, err)
+	}
+}
@@ -0,0 +1,188 @@
+package rest
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"net/http"
+	"io/ioutil"
+	"crypto/md5"
+	"path/filepath"
+	"golang.org/x/sys/unix"
+)
+
+// treePaths lists the drop zone paths merged into the resource, in
+// increasing order of precedence: the subtree under each of the layers.
+func (me *FileHandler) treePaths(rp string) []string {
+	paths := []string{}
+
+	subtree := strings.SplitN(me.subtree, "/", 2)
+	for _, tree := range layers {
+		subtree[0] = tree
+		paths = append(paths, me.root + "/" + strings.Join(subtree, "/") + strings.TrimPrefix(rp, me.prefix))
+	}
+
+	return paths
+}
+
+func fileHash(path string) ([]byte, error) {
+	hash := make([]byte, md5.Size)
+
+	if size, err := unix.Getxattr(path, HashAttr, hash); err == nil && size == md5.Size {
+		return hash, nil
+	}
+
+	// files dropped without a hash are hashed on the fly
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sum := md5.Sum(content)
+
+	return sum[:], nil
+}
+
+// resourceETag derives the entity tag from the hashes of the files of
+// the layers the options select, all of them if opts is nil. It returns
+// "" when none of them exists.
+func (me *FileHandler) resourceETag(rp string, opts *readOptions) (string, error) {
+	digest := md5.New()
+	found := false
+
+	for i, path := range me.treePaths(rp) {
+		if !opts.selected(layers[i]) {
+			continue
+		}
+		files := []string{}
+
+		err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+
+		sort.Strings(files)
+
+		for _, f := range files {
+			hash, err := fileHash(f)
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return "", err
+			}
+			fmt.Fprintf(digest, "%s\x00%x\x00", strings.TrimPrefix(f, path), hash)
+			found = true
+		}
+		fmt.Fprintf(digest, "%s\n", layers[i])
+	}
+
+	if !found {
+		return "", nil
+	}
+
+	return fmt.Sprintf("\"%x\"", digest.Sum(nil)), nil
+}
+
+// formatETag tags a representation of the resource, like "json.d1", as
+// the formats and the read options differ byte for byte.
+func formatETag(etag, representation string) string {
+	if etag == "" || representation == "" {
+		return etag
+	}
+	return strings.TrimSuffix(etag, "\"") + "-" + representation + "\""
+}
+
+// baseETag strips the representation from its tag.
+func baseETag(tag string) string {
+	if i := strings.LastIndex(tag, "-"); i > 0 && strings.HasSuffix(tag, "\"") {
+		format := strings.SplitN(tag[i+1:len(tag)-1], ".", 2)[0]
+		if _, found := contentTypes[format]; found {
+			return tag[:i] + "\""
+		}
+	}
+	return tag
+}
+
+// etagMatches compares the tags of a header with the tag of a
+// representation, or with the one of the resource if representation is
+// empty, which any representation of the same layers matches.
+func etagMatches(header, etag, representation string, weak bool) bool {
+	tag := formatETag(etag, representation)
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" {
+			return etag != ""
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		} else if strings.HasPrefix(candidate, "W/") {
+			continue
+		}
+		if representation == "" {
+			candidate = baseETag(candidate)
+		}
+		if tag != "" && candidate == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkPreconditions evaluates If-Match and If-None-Match against the
+// current state of the resource. Reads compare If-None-Match with the tag
+// of the representation their options give; writes, with nil options,
+// take the tag of any representation of all the layers. It returns the
+// status to answer with instead of processing the request, or
+// http.StatusOK.
+func (me *FileHandler) checkPreconditions(rp string, opts *readOptions, w http.ResponseWriter, r *http.Request) int {
+	etag, err := me.resourceETag(rp, opts)
+	if err != nil {
+		return http.StatusInternalServerError
+	}
+	format := opts.representation()
+
+	if match := r.Header.Get("If-Match"); match != "" && !etagMatches(match, etag, "", false) {
+		return http.StatusPreconditionFailed
+	}
+
+	if noneMatch := r.Header.Get("If-None-Match"); noneMatch != "" && etagMatches(noneMatch, etag, format, true) {
+		if r.Method == "GET" || r.Method == "HEAD" {
+			w.Header().Set("ETag", formatETag(etag, format))
+			return http.StatusNotModified
+		}
+		return http.StatusPreconditionFailed
+	}
+
+	return http.StatusOK
+}
+
+func (me *FileHandler) preconditionsHold(rp string, opts *readOptions, w http.ResponseWriter, r *http.Request) bool {
+	switch status := me.checkPreconditions(rp, opts, w, r); status {
+	case http.StatusOK:
+		return true
+	case http.StatusNotModified:
+		w.WriteHeader(status)
+	default:
+		http.Error(w, http.StatusText(status), status)
+	}
+
+	return false
+}
+
+func (me *FileHandler) setETag(rp string, opts *readOptions, w http.ResponseWriter) {
+	if etag, err := me.resourceETag(rp, opts); err == nil && etag != "" {
+		w.Header().Set("ETag", formatETag(etag, opts.representation()))
+	}
+}
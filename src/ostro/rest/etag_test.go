@@ -0,0 +1,164 @@
+package rest
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+	"net/http"
+	"net/http/httptest"
+	"ostro/confs"
+)
+
+func TestTreePaths(t *testing.T) {
+	handler := &FileHandler{root: "/run/local/dz", subtree: "local/device", prefix: "/run/local/dz/local/device"}
+
+	paths := handler.treePaths("/run/local/dz/local/device/net/local")
+	expected := []string{
+		"/run/local/dz/factory/device/net/local",
+		"/run/local/dz/common/device/net/local",
+		"/run/local/dz/local/device/net/local"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("got %v, expected %v", paths, expected)
+	}
+}
+
+func TestETagFormats(t *testing.T) {
+	etag := "\"0123abcd\""
+
+	if formatETag(etag, "ini") == formatETag(etag, "json") {
+		t.Errorf("formats share the tag %s", formatETag(etag, "ini"))
+	}
+	if !etagMatches(formatETag(etag, "ini"), etag, "ini", true) {
+		t.Errorf("tag of the INI representation does not match itself")
+	}
+	if etagMatches(formatETag(etag, "ini"), etag, "json", true) {
+		t.Errorf("tag of the INI representation matches the JSON one")
+	}
+	if !etagMatches(formatETag(etag, "xml"), etag, "", false) {
+		t.Errorf("tag of a representation does not match the resource")
+	}
+}
+
+func TestETagReadOptions(t *testing.T) {
+	root := testRoot(t)
+
+	handler, err := NewHandler(Config{Root: root, Prefix: "/confs/local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for layer, content := range map[string]string{"factory": `{"ssid": "factory"}`, "local": `{"ssid": "home"}`} {
+		if err := os.MkdirAll(filepath.Join(root, layer, "net"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(root, layer, "net", "wifi"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rp := handler.prefix + "/net"
+
+	get := func(query, noneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/confs/local/net" + query, nil)
+		if noneMatch != "" {
+			r.Header.Set("If-None-Match", noneMatch)
+		}
+		w := httptest.NewRecorder()
+		handler.getValues(rp, w, r)
+		return w
+	}
+
+	tags := map[string]string{}
+	for _, query := range []string{"", "?format=ini", "?layer=local", "?layer=factory", "?depth=0", "?provenance=true"} {
+		w := get(query, "")
+		tag := w.Header().Get("ETag")
+		if w.Code != http.StatusOK || tag == "" {
+			t.Fatalf("GET %s: got %d, tag '%s'", query, w.Code, tag)
+		}
+		for other, otherTag := range tags {
+			if tag == otherTag {
+				t.Errorf("'%s' and '%s' share the tag %s", query, other, tag)
+			}
+		}
+		tags[query] = tag
+
+		if w := get(query, tag); w.Code != http.StatusNotModified {
+			t.Errorf("GET %s with its own tag: got %d", query, w.Code)
+		}
+	}
+
+	if w := get("?layer=local", tags[""]); w.Code != http.StatusOK {
+		t.Errorf("GET ?layer=local with the merged tag: got %d", w.Code)
+	}
+
+	for query, status := range map[string]int{
+		"": http.StatusOK,
+		"?format=ini": http.StatusOK,
+		"?depth=0": http.StatusOK,
+		"?layer=local": http.StatusPreconditionFailed,
+		"?layer=factory": http.StatusPreconditionFailed,
+	} {
+		r := httptest.NewRequest("PUT", "/confs/local/net", nil)
+		r.Header.Set("If-Match", tags[query])
+		if got := handler.checkPreconditions(rp, nil, httptest.NewRecorder(), r); got != status {
+			t.Errorf("write with the tag of '%s': got %d, expected %d", query, got, status)
+		}
+	}
+
+	// the layer read is tagged by its own files only
+	if err := ioutil.WriteFile(filepath.Join(root, "factory", "net", "wifi"), []byte(`{"ssid": "changed"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if w := get("?layer=local", tags["?layer=local"]); w.Code != http.StatusNotModified {
+		t.Errorf("local layer changed with the factory one: got %d", w.Code)
+	}
+	if w := get("", tags[""]); w.Code != http.StatusOK {
+		t.Errorf("merged resource unchanged with the factory layer: got %d", w.Code)
+	}
+}
+
+func TestHead(t *testing.T) {
+	root := testRoot(t)
+
+	handler, err := NewHandler(Config{Root: root, Prefix: "/confs/local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(confs.DefinitionRoot(), "wifi.js"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "local", "wifi"), []byte(`{"ssid": "home"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(method, query, noneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/confs/local/wifi" + query, nil)
+		if noneMatch != "" {
+			r.Header.Set("If-None-Match", noneMatch)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, query := range []string{"", "?format=ini"} {
+		get := request("GET", query, "")
+		head := request("HEAD", query, "")
+
+		if head.Code != http.StatusOK || head.Body.Len() != 0 {
+			t.Errorf("HEAD %s: got %d with %d bytes", query, head.Code, head.Body.Len())
+		}
+		for _, name := range []string{"ETag", "Content-Type", "Content-Length"} {
+			if head.Header().Get(name) != get.Header().Get(name) {
+				t.Errorf("HEAD %s: %s '%s', GET has '%s'", query, name, head.Header().Get(name), get.Header().Get(name))
+			}
+		}
+
+		if w := request("HEAD", query, get.Header().Get("ETag")); w.Code != http.StatusNotModified {
+			t.Errorf("HEAD %s with the current tag: got %d", query, w.Code)
+		}
+		if w := request("HEAD", query, "\"stale\""); w.Code != http.StatusOK {
+			t.Errorf("HEAD %s with a stale tag: got %d", query, w.Code)
+		}
+	}
+}
@@ -0,0 +1,396 @@
+package rest
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"strconv"
+	"strings"
+	"net/http"
+	"encoding/hex"
+	"encoding/json"
+	"path/filepath"
+	"golang.org/x/net/websocket"
+	"ostro/watch"
+	"ostro/lifecycle"
+)
+
+const (
+	eventBacklog = 256
+	eventQueue = 64
+	keepAliveInterval = 30 * time.Second
+)
+
+type Event struct {
+	ID uint64 `json:"id"`
+	Op string `json:"op"`
+	Path string `json:"path"`
+	Origin string `json:"origin,omitempty"`
+	Hash string `json:"hash,omitempty"`
+	Value map[string]interface{} `json:"value,omitempty"`
+}
+
+// broker fans the drop zone changes out to the subscribers and keeps the
+// latest ones to replay them to clients resuming with Last-Event-ID. IDs
+// start from the startup time, so IDs of a previous run are never
+// mistaken for current ones.
+type broker struct {
+	sync.Mutex
+	root string
+	next uint64
+	backlog []Event
+	subscribers map[chan Event]bool
+}
+
+func newBroker(root string) (*broker, error) {
+	root = strings.TrimRight(root, "/")
+
+	watcher, err := watch.NewWatcher(root, root + "/tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	events := &broker{
+		root: root,
+		next: uint64(time.Now().UnixNano() / int64(time.Millisecond)),
+		subscribers: map[chan Event]bool{}}
+
+	lifecycle.OnShutdown(func() {
+		watcher.Close()
+	})
+
+	go func(w *watch.Watcher) {
+		for {
+			select {
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				events.publish(ev)
+			case err := <-w.Errors:
+				log.Printf("watching drop zone failed: %v\n", err)
+			}
+		}
+	}(watcher)
+
+	return events, nil
+}
+
+func (me *broker) publish(ev watch.Event) {
+	event := Event{
+		Op: ev.Op.String(),
+		Path: strings.TrimPrefix(ev.Path, me.root),
+		Origin: ev.Origin}
+	if ev.Hash != nil {
+		event.Hash = hex.EncodeToString(ev.Hash)
+	}
+
+	me.send(event)
+}
+
+// reset tells the clients to re-read everything, as after a factory reset.
+func (me *broker) reset(origin string) {
+	me.send(Event{Op: "reset", Path: "/", Origin: origin})
+}
+
+func (me *broker) send(event Event) {
+	me.Lock()
+	defer me.Unlock()
+
+	event.ID = me.next
+	me.next++
+
+	if len(me.backlog) >= eventBacklog {
+		me.backlog = me.backlog[1:]
+	}
+	me.backlog = append(me.backlog, event)
+
+	for ch := range me.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// a client that can't keep up has to resume with Last-Event-ID
+			delete(me.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe returns the channel of the new events and the ones missed
+// since lastID. It reports false when the missed events are not known
+// any more, in which case the client has to re-read everything.
+func (me *broker) subscribe(lastID string) (chan Event, []Event, bool) {
+	me.Lock()
+	defer me.Unlock()
+
+	ch := make(chan Event, eventQueue)
+	me.subscribers[ch] = true
+
+	if lastID == "" {
+		return ch, nil, true
+	}
+
+	id, err := strconv.ParseUint(lastID, 10, 64)
+	if err != nil || id >= me.next {
+		return ch, nil, false
+	}
+	if id + 1 == me.next {
+		return ch, nil, true
+	}
+	if len(me.backlog) == 0 || me.backlog[0].ID > id + 1 {
+		return ch, nil, false
+	}
+
+	missed := []Event{}
+	for _, ev := range me.backlog {
+		if ev.ID > id {
+			missed = append(missed, ev)
+		}
+	}
+
+	return ch, missed, true
+}
+
+func (me *broker) unsubscribe(ch chan Event) {
+	me.Lock()
+	defer me.Unlock()
+
+	if me.subscribers[ch] {
+		delete(me.subscribers, ch)
+		close(ch)
+	}
+}
+
+// eventFilter holds what a client asked for and is allowed to see.
+type eventFilter struct {
+	prefix string
+	value bool
+	id *Identity
+	handler *FileHandler
+}
+
+func (me *eventFilter) pass(ev *Event) bool {
+	if ev.Op == "reset" {
+		return true
+	}
+	if me.prefix != "/" && ev.Path != me.prefix && !strings.HasPrefix(ev.Path, me.prefix + "/") {
+		return false
+	}
+	if me.id != nil && !me.handler.auth.permits(me.id, ev.Path, false) {
+		return false
+	}
+
+	if me.value {
+		// the merged value of the node as GET reads it, if it may be read
+		if rp, ok := me.handler.resourcePath(ev.Path); ok && (me.id == nil || me.handler.auth.permits(me.id, me.handler.rulePath(rp), false)) {
+			if values, err := me.handler.readValues(rp, &readOptions{layer: "merged", depth: -1}); err == nil && len(values) > 0 {
+				ev.Value = values
+			}
+		}
+	}
+
+	return true
+}
+
+// resourcePath returns the path of the resource a drop zone path, like
+// /common/device/net/wifi, is merged into, the counterpart of treePaths.
+// It reports false for the paths outside of the subtree in every layer.
+func (me *FileHandler) resourcePath(dzPath string) (string, bool) {
+	parts := strings.SplitN(strings.TrimPrefix(dzPath, "/"), "/", 2)
+	if !isLayer(parts[0]) {
+		return "", false
+	}
+
+	subtree := "/" + me.subtree
+	path := "/" + strings.SplitN(me.subtree, "/", 2)[0] + strings.TrimPrefix(dzPath, "/" + parts[0])
+	if path != subtree && !strings.HasPrefix(path, subtree + "/") {
+		return "", false
+	}
+
+	return me.prefix + strings.TrimPrefix(path, subtree), true
+}
+
+func (me *FileHandler) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	if me.cors.Handle(w, r) {
+		return
+	}
+
+	if me.events == nil {
+		http.Error(w, "events are not enabled", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == "OPTIONS" {
+		http.Error(w, "OK", http.StatusOK)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, fmt.Sprintf("'%s' method not supported", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := &eventFilter{prefix: "/", value: false, handler: me}
+	lastID := r.Header.Get("Last-Event-ID")
+
+	for name, values := range query {
+		if len(values) != 1 {
+			http.Error(w, fmt.Sprintf("'%s' must be given once", name), http.StatusBadRequest)
+			return
+		}
+		switch name {
+		case "prefix":
+			filter.prefix = filepath.Clean("/" + values[0])
+		case "value":
+			on, err := strconv.ParseBool(values[0])
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid value '%s'", values[0]), http.StatusBadRequest)
+				return
+			}
+			filter.value = on
+		case "last-event-id":
+			// browsers can't set headers on EventSource and WebSocket
+			if lastID == "" {
+				lastID = values[0]
+			}
+		default:
+			http.Error(w, fmt.Sprintf("unknown query parameter '%s'", name), http.StatusBadRequest)
+			return
+		}
+	}
+
+	id, ok := me.authenticateRequest(w, r)
+	if !ok {
+		return
+	}
+	filter.id = id
+
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		srv := websocket.Server{
+			Handshake: me.checkWebSocketOrigin,
+			Handler: func(ws *websocket.Conn) {
+				me.streamWebSocket(ws, r, filter, lastID)
+			}}
+		srv.ServeHTTP(w, r)
+	} else {
+		me.streamSSE(w, r, filter, lastID)
+	}
+}
+
+func (me *FileHandler) checkWebSocketOrigin(config *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+
+	if config.Origin == nil || !me.cors.Allowed(r.Host, origin) {
+		return fmt.Errorf("origin '%s' is not allowed", origin)
+	}
+
+	return nil
+}
+
+func (me *FileHandler) streamSSE(w http.ResponseWriter, r *http.Request, filter *eventFilter, lastID string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ch, missed, resumed := me.events.subscribe(lastID)
+	defer me.events.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	if !resumed {
+		fmt.Fprintf(w, "event: reset\ndata: {}\n\n")
+	}
+
+	send := func(ev Event) bool {
+		if !filter.pass(&ev) {
+			return true
+		}
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return true
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Op, data)
+		return err == nil
+	}
+
+	for _, ev := range missed {
+		if !send(ev) {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok || !send(ev) {
+				return
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			if _, err := fmt.Fprintf(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (me *FileHandler) streamWebSocket(ws *websocket.Conn, r *http.Request, filter *eventFilter, lastID string) {
+	ch, missed, resumed := me.events.subscribe(lastID)
+	defer me.events.unsubscribe(ch)
+
+	closed := make(chan struct{})
+	go func() {
+		// nothing is expected from the client; reading detects the close
+		var discard []byte
+		for websocket.Message.Receive(ws, &discard) == nil {
+		}
+		close(closed)
+	}()
+
+	if !resumed {
+		if websocket.JSON.Send(ws, map[string]string{"op": "reset"}) != nil {
+			return
+		}
+	}
+
+	send := func(ev Event) bool {
+		if !filter.pass(&ev) {
+			return true
+		}
+		return websocket.JSON.Send(ws, ev) == nil
+	}
+
+	for _, ev := range missed {
+		if !send(ev) {
+			return
+		}
+	}
+
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok || !send(ev) {
+				return
+			}
+		case <-closed:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package rest
+
+import (
+	"os"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+)
+
+func TestEventValueNestedSubtree(t *testing.T) {
+	root := testRoot(t)
+
+	auth := newTestAuth(t, "/ user user\n/local/device/net/secret admin admin\n")
+	handler, err := NewHandler(Config{Root: root, Subtree: "local/device", Prefix: "/confs/device", Auth: auth})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, content := range map[string]string{
+		"common/device/net/wifi": `{"ssid": "common", "mtu": 1500}`,
+		"local/device/net/wifi": `{"ssid": "home"}`,
+		"common/device/net/secret": `{"key": "x"}`,
+		"common/net/wifi": `{"ssid": "outside"}`,
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(root, path)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(root, path), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for dzPath, rp := range map[string]string{
+		"/common/device/net/wifi": handler.prefix + "/net/wifi",
+		"/factory/device": handler.prefix,
+		"/common/net/wifi": "",
+		"/common/devices/net": "",
+		"/tmp/x": "",
+	} {
+		if got, ok := handler.resourcePath(dzPath); got != rp || ok != (rp != "") {
+			t.Errorf("'%s' is resource '%s', %v; expected '%s'", dzPath, got, ok, rp)
+		}
+	}
+
+	filter := &eventFilter{prefix: "/", value: true, id: &Identity{User: "bob", Roles: []string{"user"}}, handler: handler}
+
+	ev := &Event{Op: "write", Path: "/common/device/net/wifi"}
+	if !filter.pass(ev) {
+		t.Fatalf("event of a readable path filtered out")
+	}
+	if ev.Value["ssid"] != "home" || ev.Value["mtu"] != float64(1500) {
+		t.Errorf("value of the nested subtree: got %v", ev.Value)
+	}
+
+	ev = &Event{Op: "write", Path: "/common/net/wifi"}
+	if !filter.pass(ev) || ev.Value != nil {
+		t.Errorf("value outside of the subtree: got %v", ev.Value)
+	}
+
+	// the value is read through the rule of the resource, not of the event
+	ev = &Event{Op: "write", Path: "/common/device/net/secret"}
+	if filter.pass(ev) && ev.Value != nil {
+		t.Errorf("value of a denied resource sent: %v", ev.Value)
+	}
+}
@@ -4,16 +4,32 @@ import (
 	"fmt"
 	"os"
 	"bytes"
+	"errors"
 	"io/ioutil"
+	"crypto/md5"
 	"path/filepath"
 	"encoding/json"
 	"golang.org/x/sys/unix"
 	"ostro/confs"
+	"ostro/lifecycle"
 )
 
+var (
+	ownerError = errors.New("not owner")
+)
+
+
+func isOwner(path string) bool {
+	origin := make([]byte, len(RestOriginated))
 
+	_, err := unix.Getxattr(path, OriginAttr, origin)
 
-func traverseDirectoryTree(path string, values map[string]interface{}) error {
+	return err == nil && bytes.Equal([]byte(RestOriginated), origin)
+}
+
+// traverseDirectoryTree reads the tree under path into values. The hook,
+// when given, is called with the content of every file read.
+func traverseDirectoryTree(path string, values map[string]interface{}, hook func(string, map[string]interface{})) error {
 	var (
 		info os.FileInfo
 		entries []os.FileInfo
@@ -33,7 +49,7 @@ func traverseDirectoryTree(path string, values map[string]interface{}) error {
 		value = make(map[string]interface{})
 		
 		for _, e := range entries {
-			if err = traverseDirectoryTree(fmt.Sprintf("%s/%s", path, e.Name()), value); err != nil {
+			if err = traverseDirectoryTree(fmt.Sprintf("%s/%s", path, e.Name()), value, hook); err != nil {
 				return err
 			}
 		}		
@@ -41,6 +57,9 @@ func traverseDirectoryTree(path string, values map[string]interface{}) error {
 		if value, err = readFile(path); err != nil {
 			return err
 		}
+		if hook != nil {
+			hook(path, value)
+		}
 	}
 
 	if len(value) > 0 {
@@ -124,7 +143,7 @@ func readFile(path string) (map[string]interface{}, error) {
 	return values, nil
 }
 
-func writeFile(path string, values map[string]interface{}) error {
+func (me *FileHandler) writeFile(path string, values map[string]interface{}) error {
 	var (
 		file *os.File
 		info os.FileInfo
@@ -134,6 +153,11 @@ func writeFile(path string, values map[string]interface{}) error {
 		err error
 	)
 
+	if !lifecycle.Begin() {
+		return fmt.Errorf("shutting down")
+	}
+	defer lifecycle.End()
+
 	if info, err = os.Stat(path); err != nil {
 		if !os.IsNotExist(err) {
 			return err
@@ -146,10 +170,8 @@ func writeFile(path string, values map[string]interface{}) error {
 			return fmt.Errorf("%s is a directory", path)
 		}
 	
-		origin := make([]byte, len(RestOriginated))
-		_, err = unix.Getxattr(path, OriginAttr, origin)
-		if err != nil || !bytes.Equal([]byte(RestOriginated), origin) {
-			return fmt.Errorf("not owner of %s", path)
+		if !isOwner(path) {
+			return ownerError
 		}
 	}
 
@@ -157,9 +179,9 @@ func writeFile(path string, values map[string]interface{}) error {
 		return err
 	}
 	
-	if file, err = ioutil.TempFile(fileHandler.tmpdir, filepath.Base(path)); err == nil {
+	if file, err = ioutil.TempFile(me.tmpdir, filepath.Base(path)); err == nil {
 		if info, err = file.Stat(); err == nil {
-			tmpName = fmt.Sprintf("%s/%s", fileHandler.tmpdir, info.Name())
+			tmpName = fmt.Sprintf("%s/%s", me.tmpdir, info.Name())
 			if size, err = file.Write(content); err == nil && size != len(content) {
 				err = fmt.Errorf("partial write")
 			}
@@ -174,6 +196,12 @@ func writeFile(path string, values map[string]interface{}) error {
 		os.Remove(tmpName)
 		return err
 	}
+
+	hash := md5.Sum(content)
+	if err = unix.Setxattr(tmpName, HashAttr, hash[:], 0); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
 	
 	if err = os.Rename(tmpName, path); err != nil {
 		return err
@@ -0,0 +1,137 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"net/http"
+	"ostro/confs"
+	"ostro/session"
+)
+
+var (
+	invalidPathError = errors.New("invalid path")
+)
+
+func nodeErrorStatus(err error) int {
+	switch {
+	case err == invalidPathError:
+		return http.StatusBadRequest
+	case confs.IsDefinitionError(err):
+		return http.StatusNotFound
+	case confs.IsPathError(err):
+		return http.StatusBadRequest
+	case confs.IsConflictError(err):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// checkNode makes sure the request addresses a configuration node that
+// has a definition, either as a file or as a directory.
+func (me *FileHandler) checkNode(rp string, w http.ResponseWriter) bool {
+	if err := checkNodePath(me.rulePath(rp)); err != nil {
+		http.Error(w, err.Error(), nodeErrorStatus(err))
+		return false
+	}
+
+	return true
+}
+
+func checkNodePath(path string) error {
+	if !confs.IsValidPath(path) {
+		return invalidPathError
+	}
+
+	_, ferr := confs.CheckFilePath(path, false)
+	if ferr == nil {
+		return nil
+	}
+	if _, derr := confs.CheckDirPath(path, false); derr == nil {
+		return nil
+	}
+
+	return ferr
+}
+
+// ReadNode returns the merged values of a node, like /network/wifi, the
+// way GET does. With authentication it needs the session of a user who
+// may read the node, or else fails with session.AccessError.
+func (me *FileHandler) ReadNode(node string, s *session.Session) (map[string]interface{}, error) {
+	rp := me.prefix + node
+
+	if err := me.nodeAccess(rp, s, false); err != nil {
+		return nil, err
+	}
+
+	return me.readValues(rp, &readOptions{layer: "merged", depth: -1})
+}
+
+// WriteNode merges values into the local values of a node the way PUT
+// does, for the users of the session.
+func (me *FileHandler) WriteNode(node string, values map[string]interface{}, s *session.Session) error {
+	rp := me.prefix + node
+
+	if err := me.nodeAccess(rp, s, true); err != nil {
+		return err
+	}
+
+	me.writeLock.Lock()
+	defer me.writeLock.Unlock()
+
+	dropPath, cerr := confs.CheckFilePath(me.rulePath(rp), true)
+	if cerr != nil {
+		return cerr
+	}
+	if dropPath != rp {
+		return fmt.Errorf("REST prefix is outside of the drop zone")
+	}
+
+	current, err := readFile(rp)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		current = make(map[string]interface{})
+	}
+
+	if err = confs.MergeFragment(values, current); err != nil {
+		return err
+	}
+
+	return me.writeFile(rp, current)
+}
+
+func (me *FileHandler) nodeAccess(rp string, s *session.Session, write bool) error {
+	path := me.rulePath(rp)
+
+	if err := checkNodePath(path); err != nil {
+		return err
+	}
+
+	if me.auth == nil {
+		return nil
+	}
+	if s == nil || !me.auth.permits(&Identity{User: s.User, Roles: s.Roles}, path, write) {
+		return session.AccessError
+	}
+
+	return nil
+}
+
+// writablePath validates the request through the confs checks and
+// returns the drop zone file to be written, creating its directories.
+func (me *FileHandler) writablePath(rp string, w http.ResponseWriter) (string, bool) {
+	dropPath, err := confs.CheckFilePath(me.rulePath(rp), true)
+	if err != nil {
+		http.Error(w, err.Error(), nodeErrorStatus(err))
+		return "", false
+	}
+
+	if dropPath != rp {
+		http.Error(w, "REST prefix is outside of the drop zone", http.StatusInternalServerError)
+		return "", false
+	}
+
+	return dropPath, true
+}
@@ -0,0 +1,377 @@
+package rest
+
+import (
+	"os"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"strings"
+	"net/http"
+	"crypto/md5"
+	"path/filepath"
+	"encoding/hex"
+	"encoding/json"
+	"ostro/confs"
+)
+
+type jsonObject map[string]interface{}
+
+// apiDocument is the OpenAPI document of the definition tree. It is
+// rebuilt when a definition file is added, removed or modified.
+type apiDocument struct {
+	sync.Mutex
+	fingerprint string
+	content []byte
+}
+
+type apiNode struct {
+	path string		// like /network/wifi, / for root.js
+	file string
+	def confs.Definition
+	children []string
+}
+
+// definitionFiles lists the definition files by node path and returns
+// their fingerprint, which changes with every change of the files.
+func definitionFiles(root string) (map[string]string, string, error) {
+	files := map[string]string{}
+	stamps := []string{}
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && path == root + "/infra" {
+			return filepath.SkipDir
+		}
+		if !info.Mode().IsRegular() || !strings.HasSuffix(path, ".js") {
+			return nil
+		}
+
+		node := strings.TrimSuffix(strings.TrimPrefix(path, root), ".js")
+		if node == "/root" {
+			node = "/"
+		}
+		if !confs.IsValidPath(node) {
+			return nil
+		}
+
+		files[node] = path
+		stamps = append(stamps, fmt.Sprintf("%s %d %d", path, info.ModTime().UnixNano(), info.Size()))
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	sort.Strings(stamps)
+	sum := md5.Sum([]byte(strings.Join(stamps, "\n")))
+
+	return files, hex.EncodeToString(sum[:]), nil
+}
+
+func (me *FileHandler) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if me.cors.Handle(w, r) {
+		return
+	}
+
+	if r.Method == "OPTIONS" {
+		http.Error(w, "OK", http.StatusOK)
+		return
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, fmt.Sprintf("'%s' method not supported", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, ok := me.authenticateRequest(w, r); !ok {
+		return
+	}
+
+	content, fingerprint, err := me.apiDoc.get(me.pattern)
+	if err != nil {
+		log.Printf("generating the OpenAPI document failed: %v\n", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	etag := "\"" + fingerprint + "\""
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag, "", true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(content)))
+
+	if r.Method == "GET" {
+		w.Write(content)
+	}
+}
+
+func (me *apiDocument) get(pattern string) ([]byte, string, error) {
+	me.Lock()
+	defer me.Unlock()
+
+	files, fingerprint, err := definitionFiles(confs.DefinitionRoot())
+	if err != nil {
+		return nil, "", err
+	}
+
+	if fingerprint != me.fingerprint || me.content == nil {
+		doc := generateOpenAPI(pattern, readNodes(files))
+		content, err := json.MarshalIndent(doc, "", "    ")
+		if err != nil {
+			return nil, "", err
+		}
+		me.content = content
+		me.fingerprint = fingerprint
+		confs.Debugf("OpenAPI document regenerated (%d definitions)", len(files))
+	}
+
+	return me.content, me.fingerprint, nil
+}
+
+func readNodes(files map[string]string) map[string]*apiNode {
+	nodes := map[string]*apiNode{}
+
+	for path, file := range files {
+		def, err := confs.ReadDefinition(file)
+		if err != nil {
+			confs.Errorf("skipping definition '%s': %v", file, err)
+			continue
+		}
+		nodes[path] = &apiNode{path: path, file: file, def: def}
+	}
+
+	for path := range nodes {
+		if path == "/" {
+			continue
+		}
+		if parent, found := nodes[filepath.Dir(path)]; found {
+			parent.children = append(parent.children, filepath.Base(path))
+		}
+	}
+	for _, node := range nodes {
+		sort.Strings(node.children)
+	}
+
+	return nodes
+}
+
+func schemaName(path string) string {
+	if path == "/" {
+		return "root"
+	}
+	return strings.Replace(strings.TrimPrefix(path, "/"), "/", ".", -1)
+}
+
+func schemaRef(path string) jsonObject {
+	return jsonObject{"$ref": "#/components/schemas/" + schemaName(path)}
+}
+
+func generateOpenAPI(pattern string, nodes map[string]*apiNode) jsonObject {
+	paths := jsonObject{}
+	schemas := jsonObject{
+		"JsonPatch": jsonObject{
+			"type": "array",
+			"items": jsonObject{
+				"type": "object",
+				"required": []string{"op", "path"},
+				"properties": jsonObject{
+					"op": jsonObject{"type": "string", "enum": []string{"add", "remove", "replace", "move", "copy", "test"}},
+					"path": jsonObject{"type": "string"},
+					"from": jsonObject{"type": "string"},
+					"value": jsonObject{}}}}}
+
+	for path, node := range nodes {
+		schemas[schemaName(path)] = nodeSchema(node)
+
+		url := pattern + path
+		if path == "/" {
+			url = pattern + "/"
+		}
+		paths[url] = nodeOperations(node)
+	}
+
+	return jsonObject{
+		"openapi": "3.0.3",
+		"info": jsonObject{
+			"title": "Configuration",
+			"description": "Configuration nodes of the definition tree, merged from the factory, common and local layers.",
+			"version": "1"},
+		"paths": paths,
+		"components": jsonObject{
+			"schemas": schemas,
+			"parameters": jsonObject{
+				"layer": jsonObject{"name": "layer", "in": "query", "schema": jsonObject{"type": "string", "enum": append([]string{"merged"}, layers...)}},
+				"provenance": jsonObject{"name": "provenance", "in": "query", "schema": jsonObject{"type": "boolean"}},
+				"format": jsonObject{"name": "format", "in": "query", "schema": jsonObject{"type": "string", "enum": []string{"json", "xml", "ini"}}},
+				"depth": jsonObject{"name": "depth", "in": "query", "schema": jsonObject{"type": "integer", "minimum": 0}},
+				"If-Match": jsonObject{"name": "If-Match", "in": "header", "schema": jsonObject{"type": "string"}},
+				"If-None-Match": jsonObject{"name": "If-None-Match", "in": "header", "schema": jsonObject{"type": "string"}}}}}
+}
+
+func parameterRefs(names ...string) []jsonObject {
+	refs := []jsonObject{}
+	for _, name := range names {
+		refs = append(refs, jsonObject{"$ref": "#/components/parameters/" + name})
+	}
+	return refs
+}
+
+func textResponse(description string) jsonObject {
+	return jsonObject{
+		"description": description,
+		"content": jsonObject{"text/plain": jsonObject{"schema": jsonObject{"type": "string"}}}}
+}
+
+func nodeOperations(node *apiNode) jsonObject {
+	ref := schemaRef(node.path)
+	summary, _ := node.def["title"].(string)
+	if summary == "" {
+		summary = node.path
+	}
+
+	written := jsonObject{
+		"200": textResponse("written"),
+		"400": textResponse("malformed content or path"),
+		"404": textResponse("no such node"),
+		"409": textResponse("the node is owned by somebody else"),
+		"412": textResponse("the node was changed meanwhile"),
+		"413": textResponse("the content is too large"),
+		"415": textResponse("unsupported content type")}
+
+	return jsonObject{
+		"summary": summary,
+		"get": jsonObject{
+			"operationId": "get." + schemaName(node.path),
+			"parameters": parameterRefs("layer", "provenance", "format", "depth", "If-None-Match"),
+			"responses": jsonObject{
+				"200": jsonObject{
+					"description": "values of the node",
+					"content": jsonObject{
+						"application/json": jsonObject{"schema": ref},
+						"application/xml": jsonObject{"schema": ref},
+						"text/plain": jsonObject{"schema": jsonObject{"type": "string"}}}},
+				"304": jsonObject{"description": "not modified"},
+				"404": textResponse("no such node")}},
+		"put": jsonObject{
+			"operationId": "put." + schemaName(node.path),
+			"description": "Merges the values into the local layer.",
+			"parameters": parameterRefs("If-Match"),
+			"requestBody": jsonObject{
+				"required": true,
+				"content": jsonObject{
+					"application/json": jsonObject{"schema": ref},
+					"application/xml": jsonObject{"schema": ref},
+					"text/x-ini": jsonObject{"schema": jsonObject{"type": "string"}}}},
+			"responses": written},
+		"patch": jsonObject{
+			"operationId": "patch." + schemaName(node.path),
+			"parameters": parameterRefs("If-Match"),
+			"requestBody": jsonObject{
+				"required": true,
+				"content": jsonObject{
+					MergePatchType: jsonObject{"schema": ref},
+					JsonPatchType: jsonObject{"schema": jsonObject{"$ref": "#/components/schemas/JsonPatch"}}}},
+			"responses": written},
+		"delete": jsonObject{
+			"operationId": "delete." + schemaName(node.path),
+			"description": "Removes the values from the local layer.",
+			"parameters": parameterRefs("If-Match"),
+			"responses": jsonObject{
+				"200": textResponse("removed"),
+				"404": textResponse("no such node or no values"),
+				"409": textResponse("the node is owned by somebody else"),
+				"412": textResponse("the node was changed meanwhile")}}}
+}
+
+func nodeSchema(node *apiNode) jsonObject {
+	schema := jsonObject{"type": "object"}
+	if title, ok := node.def["title"].(string); ok {
+		schema["title"] = title
+	}
+
+	properties := jsonObject{}
+
+	if fields, ok := node.def["fields"].(map[string]interface{}); ok {
+		for name, field := range fields {
+			if def, ok := field.(map[string]interface{}); ok {
+				properties[name] = fieldSchema(def)
+			}
+		}
+	}
+	for _, child := range node.children {
+		properties[child] = schemaRef(filepath.Join(node.path, child))
+	}
+
+	schema["properties"] = properties
+
+	return schema
+}
+
+// fieldSchema maps a field definition as rendered by formgen.js.
+func fieldSchema(def map[string]interface{}) jsonObject {
+	schema := jsonObject{}
+	if desc, ok := def["desc"].(string); ok {
+		schema["description"] = desc
+	}
+
+	typ, _ := def["type"].(string)
+
+	switch typ {
+	case "checkbox":
+		schema["type"] = "boolean"
+	case "number":
+		schema["type"] = "integer"
+		if min, ok := def["min"].(float64); ok {
+			schema["minimum"] = min
+		}
+		if max, ok := def["max"].(float64); ok {
+			schema["maximum"] = max
+		}
+	case "select":
+		schema["type"] = "string"
+		if options, ok := def["options"].(map[string]interface{}); ok {
+			enum := append([]string{}, confs.Keys(options)...)
+			sort.Strings(enum)
+			schema["enum"] = enum
+		}
+	case "section":
+		if fields, ok := def["fields"].(map[string]interface{}); ok {
+			properties := jsonObject{}
+			for name, field := range fields {
+				if fd, ok := field.(map[string]interface{}); ok {
+					properties[name] = fieldSchema(fd)
+				}
+			}
+			schema["type"] = "object"
+			schema["properties"] = properties
+		} else if value, ok := def["value"].(map[string]interface{}); ok {
+			for k, v := range fieldSchema(value) {
+				if _, set := schema[k]; !set {
+					schema[k] = v
+				}
+			}
+		}
+	case "password":
+		schema["type"] = "string"
+		schema["format"] = "password"
+	default:
+		schema["type"] = "string"
+	}
+
+	if schema["type"] == "string" {
+		if pattern, ok := def["pattern"].(string); ok {
+			schema["pattern"] = "^(?:" + pattern + ")$"
+		}
+	}
+	if defval, found := def["defval"]; found && defval != nil {
+		schema["default"] = defval
+	}
+
+	return schema
+}
@@ -0,0 +1,442 @@
+package rest
+
+import (
+	"fmt"
+	"os"
+	"errors"
+	"strconv"
+	"strings"
+	"reflect"
+	"net/http"
+	"path/filepath"
+	"encoding/json"
+	"ostro/lifecycle"
+)
+
+const (
+	MergePatchType = "application/merge-patch+json"
+	JsonPatchType  = "application/json-patch+json"
+)
+
+var (
+	patchError = errors.New("malformed patch")
+	testError  = errors.New("test failed")
+	targetError = errors.New("target does not exist")
+)
+
+type patchOperation struct {
+	Op string `json:"op"`
+	Path *string `json:"path"`
+	From *string `json:"from"`
+	Value interface{} `json:"value"`
+}
+
+func (me *FileHandler) patchValues(rp string, w http.ResponseWriter, r *http.Request) {
+	var (
+		values map[string]interface{}
+		result interface{}
+		err error
+	)
+
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(r.Header.Get("Content-Type"), ";")[0]))
+	if mediaType != MergePatchType && mediaType != JsonPatchType {
+		w.Header().Set("Accept-Patch", MergePatchType + ", " + JsonPatchType)
+		http.Error(w, fmt.Sprintf("unsupported patch format '%s'", mediaType),
+			http.StatusUnsupportedMediaType)
+		return
+	}
+
+	if !me.preconditionsHold(rp, nil, w, r) {
+		return
+	}
+
+	content, ok := me.readContent(w, r)
+	if !ok {
+		return
+	}
+
+	if rp, ok = me.writablePath(rp, w); !ok {
+		return
+	}
+
+	if values, err = readFile(rp); err != nil {
+		if !os.IsNotExist(err) {
+			http.Error(w, fmt.Sprintf("error during read '%s': %v", rp, err),
+				http.StatusInternalServerError)
+			return
+		}
+		values = make(map[string]interface{})
+	}
+
+	if mediaType == MergePatchType {
+		var patch interface{}
+		if err = json.Unmarshal(content, &patch); err != nil {
+			http.Error(w, fmt.Sprintf("malformed request content: %v", err),
+				http.StatusBadRequest)
+			return
+		}
+		result = mergePatch(values, patch)
+	} else {
+		var patch []patchOperation
+		if err = json.Unmarshal(content, &patch); err != nil {
+			http.Error(w, fmt.Sprintf("malformed request content: %v", err),
+				http.StatusBadRequest)
+			return
+		}
+		if result, err = jsonPatch(values, patch); err != nil {
+			status := http.StatusConflict
+			if err == patchError {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, fmt.Sprintf("patching '%s' failed: %v", rp, err), status)
+			return
+		}
+	}
+
+	if values, ok = result.(map[string]interface{}); !ok {
+		http.Error(w, "patch result is not an object", http.StatusConflict)
+		return
+	}
+
+	if err = me.writeFile(rp, values); err != nil {
+		http.Error(w, fmt.Sprintf("writing to '%s' failed: %v", rp, err),
+			writeErrorStatus(err))
+		return
+	}
+
+	me.setETag(rp, defaultReadOptions(), w)
+	http.Error(w, "OK", http.StatusOK)
+}
+
+func (me *FileHandler) deleteValues(rp string, w http.ResponseWriter, r *http.Request) {
+	if !me.preconditionsHold(rp, nil, w, r) {
+		return
+	}
+
+	info, err := os.Stat(rp)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, fmt.Sprintf("'%s' does not exist", rp), http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if info.IsDir() && rp == me.prefix {
+		http.Error(w, "the root can't be deleted", http.StatusConflict)
+		return
+	}
+
+	// nothing is removed unless every file of the subtree is ours
+	err = filepath.Walk(rp, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !isOwner(path) {
+			return ownerError
+		}
+		return nil
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("deleting '%s' failed: %v", rp, err),
+			writeErrorStatus(err))
+		return
+	}
+
+	if err = me.removePath(rp); err != nil {
+		http.Error(w, fmt.Sprintf("deleting '%s' failed: %v", rp, err),
+			http.StatusInternalServerError)
+		return
+	}
+
+	http.Error(w, "OK", http.StatusOK)
+}
+
+func mergePatch(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = make(map[string]interface{})
+	}
+
+	for name, value := range patchObj {
+		if value == nil {
+			delete(targetObj, name)
+		} else {
+			targetObj[name] = mergePatch(targetObj[name], value)
+		}
+	}
+
+	return targetObj
+}
+
+func jsonPatch(doc interface{}, patch []patchOperation) (interface{}, error) {
+	var err error
+
+	for _, op := range patch {
+		if op.Path == nil {
+			return nil, patchError
+		}
+
+		switch op.Op {
+		case "add":
+			doc, err = addValue(doc, *op.Path, op.Value)
+		case "remove":
+			doc, _, err = removeValue(doc, *op.Path)
+		case "replace":
+			if doc, _, err = removeValue(doc, *op.Path); err == nil {
+				doc, err = addValue(doc, *op.Path, op.Value)
+			}
+		case "move", "copy":
+			var value interface{}
+			if op.From == nil {
+				return nil, patchError
+			}
+			if op.Op == "move" {
+				if *op.Path == *op.From {
+					continue
+				}
+				if strings.HasPrefix(*op.Path, *op.From + "/") {
+					return nil, fmt.Errorf("can't move '%s' into itself", *op.From)
+				}
+				doc, value, err = removeValue(doc, *op.From)
+			} else if value, err = getValue(doc, *op.From); err == nil {
+				value = deepCopy(value)
+			}
+			if err == nil {
+				doc, err = addValue(doc, *op.Path, value)
+			}
+		case "test":
+			var value interface{}
+			if value, err = getValue(doc, *op.Path); err == nil && !reflect.DeepEqual(value, op.Value) {
+				err = testError
+			}
+		default:
+			return nil, patchError
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return doc, nil
+}
+
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if pointer[0] != '/' {
+		return nil, patchError
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+	}
+
+	return tokens, nil
+}
+
+func arrayIndex(token string, length int, appending bool) (int, error) {
+	if appending && token == "-" {
+		return length, nil
+	}
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, targetError
+	}
+
+	idx, err := strconv.Atoi(token)
+	if err != nil || idx < 0 || idx > length || (idx == length && !appending) {
+		return 0, targetError
+	}
+
+	return idx, nil
+}
+
+func getValue(doc interface{}, pointer string) (interface{}, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range tokens {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			var found bool
+			if doc, found = node[t]; !found {
+				return nil, targetError
+			}
+		case []interface{}:
+			idx, err := arrayIndex(t, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[idx]
+		default:
+			return nil, targetError
+		}
+	}
+
+	return doc, nil
+}
+
+func splitPointer(pointer string) (string, string, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return "", "", err
+	}
+	if len(tokens) == 0 {
+		return "", "", nil
+	}
+
+	idx := strings.LastIndex(pointer, "/")
+
+	return pointer[:idx], tokens[len(tokens)-1], nil
+}
+
+func addValue(doc interface{}, pointer string, value interface{}) (interface{}, error) {
+	if pointer == "" {
+		return value, nil
+	}
+
+	parentPtr, last, err := splitPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	parent, err := getValue(doc, parentPtr)
+	if err != nil {
+		return nil, err
+	}
+
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[last] = value
+	case []interface{}:
+		idx, err := arrayIndex(last, len(node), true)
+		if err != nil {
+			return nil, err
+		}
+		node = append(node, nil)
+		copy(node[idx+1:], node[idx:])
+		node[idx] = value
+		return replaceArray(doc, parentPtr, node)
+	default:
+		return nil, targetError
+	}
+
+	return doc, nil
+}
+
+func removeValue(doc interface{}, pointer string) (interface{}, interface{}, error) {
+	if pointer == "" {
+		return nil, doc, nil
+	}
+
+	parentPtr, last, err := splitPointer(pointer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parent, err := getValue(doc, parentPtr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		value, found := node[last]
+		if !found {
+			return nil, nil, targetError
+		}
+		delete(node, last)
+		return doc, value, nil
+	case []interface{}:
+		idx, err := arrayIndex(last, len(node), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		value := node[idx]
+		node = append(node[:idx:idx], node[idx+1:]...)
+		doc, err = replaceArray(doc, parentPtr, node)
+		return doc, value, err
+	}
+
+	return nil, nil, targetError
+}
+
+func replaceArray(doc interface{}, pointer string, array []interface{}) (interface{}, error) {
+	if pointer == "" {
+		return array, nil
+	}
+
+	parentPtr, last, err := splitPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	parent, err := getValue(doc, parentPtr)
+	if err != nil {
+		return nil, err
+	}
+
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[last] = array
+	case []interface{}:
+		idx, err := arrayIndex(last, len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		node[idx] = array
+	default:
+		return nil, targetError
+	}
+
+	return doc, nil
+}
+
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for name, item := range v {
+			c[name] = deepCopy(item)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, item := range v {
+			c[i] = deepCopy(item)
+		}
+		return c
+	}
+	return value
+}
+
+func (me *FileHandler) removePath(path string) error {
+	if !lifecycle.Begin() {
+		return fmt.Errorf("shutting down")
+	}
+	defer lifecycle.End()
+
+	if err := os.RemoveAll(path); err != nil {
+		return err
+	}
+
+	// drop the directories left empty, but never the prefix itself
+	for dir := filepath.Dir(path);  strings.HasPrefix(dir, me.prefix + "/");  dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package rest
+
+import (
+	"testing"
+	"reflect"
+	"encoding/json"
+)
+
+func decodeJson(t *testing.T, content string) interface{} {
+	var value interface{}
+	if err := json.Unmarshal([]byte(content), &value); err != nil {
+		t.Fatalf("'%s': %v", content, err)
+	}
+	return value
+}
+
+// The examples of RFC 6902 appendix A, and a few more.
+func TestJsonPatch(t *testing.T) {
+	for _, c := range []struct{
+		name string
+		doc string
+		patch string
+		result string
+		err error
+	}{
+		{"A.1 add an object member", `{"foo": "bar"}`,
+			`[{"op": "add", "path": "/baz", "value": "qux"}]`,
+			`{"baz": "qux", "foo": "bar"}`, nil},
+		{"A.2 add an array element", `{"foo": ["bar", "baz"]}`,
+			`[{"op": "add", "path": "/foo/1", "value": "qux"}]`,
+			`{"foo": ["bar", "qux", "baz"]}`, nil},
+		{"A.3 remove an object member", `{"baz": "qux", "foo": "bar"}`,
+			`[{"op": "remove", "path": "/baz"}]`,
+			`{"foo": "bar"}`, nil},
+		{"A.4 remove an array element", `{"foo": ["bar", "qux", "baz"]}`,
+			`[{"op": "remove", "path": "/foo/1"}]`,
+			`{"foo": ["bar", "baz"]}`, nil},
+		{"A.5 replace a value", `{"baz": "qux", "foo": "bar"}`,
+			`[{"op": "replace", "path": "/baz", "value": "boo"}]`,
+			`{"baz": "boo", "foo": "bar"}`, nil},
+		{"A.6 move a value", `{"foo": {"bar": "baz", "waldo": "fred"}, "qux": {"corge": "grault"}}`,
+			`[{"op": "move", "from": "/foo/waldo", "path": "/qux/thud"}]`,
+			`{"foo": {"bar": "baz"}, "qux": {"corge": "grault", "thud": "fred"}}`, nil},
+		{"A.7 move an array element", `{"foo": ["all", "grass", "cows", "eat"]}`,
+			`[{"op": "move", "from": "/foo/1", "path": "/foo/3"}]`,
+			`{"foo": ["all", "cows", "eat", "grass"]}`, nil},
+		{"A.8 test a value", `{"baz": "qux", "foo": ["a", 2, "c"]}`,
+			`[{"op": "test", "path": "/baz", "value": "qux"}, {"op": "test", "path": "/foo/1", "value": 2}]`,
+			`{"baz": "qux", "foo": ["a", 2, "c"]}`, nil},
+		{"A.9 failed test", `{"baz": "qux"}`,
+			`[{"op": "test", "path": "/baz", "value": "bar"}]`,
+			"", testError},
+		{"A.10 add a nested member", `{"foo": "bar"}`,
+			`[{"op": "add", "path": "/child", "value": {"grandchild": {}}}]`,
+			`{"foo": "bar", "child": {"grandchild": {}}}`, nil},
+		{"A.11 ignore unknown members", `{"foo": "bar"}`,
+			`[{"op": "add", "path": "/baz", "value": "qux", "xyz": 123}]`,
+			`{"foo": "bar", "baz": "qux"}`, nil},
+		{"A.12 add to a nonexistent target", `{"foo": "bar"}`,
+			`[{"op": "add", "path": "/baz/bat", "value": "qux"}]`,
+			"", targetError},
+		{"A.14 ~ escapes", `{"/": 9, "~1": 10}`,
+			`[{"op": "test", "path": "/~01", "value": 10}, {"op": "add", "path": "/a~1b~0c", "value": 1}]`,
+			`{"/": 9, "~1": 10, "a/b~c": 1}`, nil},
+		{"A.15 compare strings and numbers", `{"/": 9, "~1": 10}`,
+			`[{"op": "test", "path": "/~01", "value": "10"}]`,
+			"", testError},
+		{"A.16 add an array value", `{"foo": ["bar"]}`,
+			`[{"op": "add", "path": "/foo/-", "value": ["abc", "def"]}]`,
+			`{"foo": ["bar", ["abc", "def"]]}`, nil},
+		{"- only appends", `{"foo": ["bar"]}`,
+			`[{"op": "remove", "path": "/foo/-"}]`,
+			"", targetError},
+		{"index past the end", `{"foo": ["bar"]}`,
+			`[{"op": "add", "path": "/foo/2", "value": 1}]`,
+			"", targetError},
+		{"leading zero index", `{"foo": ["bar", "baz"]}`,
+			`[{"op": "remove", "path": "/foo/01"}]`,
+			"", targetError},
+		{"move into its own child", `{"foo": {"bar": 1}}`,
+			`[{"op": "move", "from": "/foo", "path": "/foo/bar/baz"}]`,
+			"", patchError},
+		{"move onto itself", `{"foo": {"bar": 1}}`,
+			`[{"op": "move", "from": "/foo", "path": "/foo"}]`,
+			`{"foo": {"bar": 1}}`, nil},
+		{"move into a sibling with a common prefix", `{"foo": 1, "foobar": {}}`,
+			`[{"op": "move", "from": "/foo", "path": "/foobar/foo"}]`,
+			`{"foobar": {"foo": 1}}`, nil},
+		{"copy is deep", `{"foo": {"bar": [1]}}`,
+			`[{"op": "copy", "from": "/foo", "path": "/baz"}, {"op": "add", "path": "/baz/bar/-", "value": 2}]`,
+			`{"foo": {"bar": [1]}, "baz": {"bar": [1, 2]}}`, nil},
+		{"copy an array element", `{"foo": ["a", "b"]}`,
+			`[{"op": "copy", "from": "/foo/0", "path": "/foo/-"}]`,
+			`{"foo": ["a", "b", "a"]}`, nil},
+		{"test a whole array", `{"foo": ["a", {"b": 1}]}`,
+			`[{"op": "test", "path": "/foo", "value": ["a", {"b": 1}]}]`,
+			`{"foo": ["a", {"b": 1}]}`, nil},
+		{"test an array in another order", `{"foo": ["a", "b"]}`,
+			`[{"op": "test", "path": "/foo", "value": ["b", "a"]}]`,
+			"", testError},
+		{"replace a missing value", `{"foo": 1}`,
+			`[{"op": "replace", "path": "/bar", "value": 2}]`,
+			"", targetError},
+		{"unknown operation", `{"foo": 1}`,
+			`[{"op": "append", "path": "/foo", "value": 2}]`,
+			"", patchError},
+		{"missing path", `{"foo": 1}`,
+			`[{"op": "remove"}]`,
+			"", patchError},
+		{"copy without from", `{"foo": 1}`,
+			`[{"op": "copy", "path": "/bar"}]`,
+			"", patchError},
+	} {
+		var patch []patchOperation
+		if err := json.Unmarshal([]byte(c.patch), &patch); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+
+		result, err := jsonPatch(decodeJson(t, c.doc), patch)
+		if c.err != nil {
+			if err == nil {
+				t.Errorf("%s: got %v, expected an error", c.name, result)
+			} else if c.err != patchError && err != c.err {
+				t.Errorf("%s: got error '%v', expected '%v'", c.name, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		} else if expected := decodeJson(t, c.result); !reflect.DeepEqual(result, expected) {
+			t.Errorf("%s: got %v, expected %v", c.name, result, expected)
+		}
+	}
+}
+
+// The examples of RFC 7396 appendix A.
+func TestMergePatch(t *testing.T) {
+	for _, c := range []struct{
+		target string
+		patch string
+		result string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	} {
+		result := mergePatch(decodeJson(t, c.target), decodeJson(t, c.patch))
+		if expected := decodeJson(t, c.result); !reflect.DeepEqual(result, expected) {
+			t.Errorf("%s merged with %s: got %v, expected %s", c.target, c.patch, result, c.result)
+		}
+	}
+}
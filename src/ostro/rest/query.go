@@ -0,0 +1,168 @@
+package rest
+
+import (
+	"fmt"
+	"strconv"
+	"net/url"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	layers = []string{"factory", "common", "local"}
+
+	contentTypes = map[string]string{
+		"json": "application/json",
+		"xml": "application/xml",
+		"ini": "text/plain; charset=utf-8"}
+)
+
+// readOptions are the query parameters accepted by GET.
+type readOptions struct {
+	layer string
+	provenance bool
+	format string
+	depth int
+}
+
+// leafSource replaces a leaf when provenance is asked for. Being a struct,
+// it is never merged into the objects of the lower layers.
+type leafSource struct {
+	Value interface{} `json:"value"`
+	Layer string `json:"layer"`
+	Origin string `json:"origin,omitempty"`
+	File string `json:"file"`
+}
+
+// defaultReadOptions are the options of a GET without a query, whose
+// representation the writes answer with.
+func defaultReadOptions() *readOptions {
+	return &readOptions{layer: "merged", format: "json", depth: -1}
+}
+
+func parseReadOptions(query url.Values) (*readOptions, error) {
+	opts := defaultReadOptions()
+
+	for name, values := range query {
+		if len(values) != 1 {
+			return nil, fmt.Errorf("'%s' must be given once", name)
+		}
+		value := values[0]
+
+		switch name {
+		case "layer":
+			if value != "merged" && !isLayer(value) {
+				return nil, fmt.Errorf("invalid layer '%s'", value)
+			}
+			opts.layer = value
+		case "provenance":
+			on, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid provenance '%s'", value)
+			}
+			opts.provenance = on
+		case "format":
+			if _, found := contentTypes[value]; !found {
+				return nil, fmt.Errorf("invalid format '%s'", value)
+			}
+			opts.format = value
+		case "depth":
+			depth, err := strconv.Atoi(value)
+			if err != nil || depth < 0 {
+				return nil, fmt.Errorf("invalid depth '%s'", value)
+			}
+			opts.depth = depth
+		default:
+			return nil, fmt.Errorf("unknown query parameter '%s'", name)
+		}
+	}
+
+	return opts, nil
+}
+
+func isLayer(name string) bool {
+	for _, l := range layers {
+		if l == name {
+			return true
+		}
+	}
+	return false
+}
+
+// selected tells whether the layer is read; all of them are without
+// options.
+func (me *readOptions) selected(layer string) bool {
+	return me == nil || me.layer == "merged" || me.layer == layer
+}
+
+// representation names the body the options make of a resource, like
+// "json" or "ini.local.d1.p", for its tag. It is "" without options.
+func (me *readOptions) representation() string {
+	if me == nil {
+		return ""
+	}
+
+	name := me.format
+	if me.layer != "merged" {
+		name += "." + me.layer
+	}
+	if me.depth >= 0 {
+		name += fmt.Sprintf(".d%d", me.depth)
+	}
+	if me.provenance {
+		name += ".p"
+	}
+
+	return name
+}
+
+// annotator returns the hook marking the leaves of the files read from
+// the layer with their source.
+func (me *readOptions) annotator(layer string) func(string, map[string]interface{}) {
+	if !me.provenance {
+		return nil
+	}
+
+	return func(file string, values map[string]interface{}) {
+		origin := make([]byte, 64)
+		if size, err := unix.Getxattr(file, OriginAttr, origin); err == nil {
+			origin = origin[:size]
+		} else {
+			origin = origin[:0]
+		}
+		annotate(values, leafSource{Layer: layer, Origin: string(origin), File: file})
+	}
+}
+
+func annotate(values map[string]interface{}, source leafSource) {
+	for name, value := range values {
+		if object, ok := value.(map[string]interface{}); ok {
+			annotate(object, source)
+		} else {
+			leaf := source
+			leaf.Value = value
+			values[name] = leaf
+		}
+	}
+}
+
+// shape turns the annotated leaves into plain objects, so that every
+// format can carry them, and cuts the objects nested deeper than the
+// requested depth.
+func (me *readOptions) shape(values map[string]interface{}, level int) {
+	for name, value := range values {
+		switch v := value.(type) {
+		case leafSource:
+			source := map[string]interface{}{"value": v.Value, "layer": v.Layer, "file": v.File}
+			if v.Origin != "" {
+				source["origin"] = v.Origin
+			}
+			values[name] = source
+		case map[string]interface{}:
+			if me.depth >= 0 && level >= me.depth {
+				values[name] = map[string]interface{}{}
+			} else {
+				me.shape(v, level + 1)
+			}
+		}
+	}
+}
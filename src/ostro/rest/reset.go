@@ -0,0 +1,106 @@
+package rest
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"net/http"
+	"encoding/json"
+	"ostro/confs"
+)
+
+func (me *FileHandler) resetHandler(w http.ResponseWriter, r *http.Request) {
+	if me.cors.Handle(w, r) {
+		return
+	}
+
+	if r.Method == "OPTIONS" {
+		http.Error(w, "OK", http.StatusOK)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, fmt.Sprintf("'%s' method not supported", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	opts := confs.ResetOptions{Origin: RestOriginated}
+
+	for name, values := range r.URL.Query() {
+		switch name {
+		case "dry-run", "common":
+			on, err := strconv.ParseBool(values[0])
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s '%s'", name, values[0]), http.StatusBadRequest)
+				return
+			}
+			if name == "common" {
+				opts.Common = on
+			} else {
+				opts.DryRun = on
+			}
+		case "scope":
+			for _, value := range values {
+				opts.Scopes = append(opts.Scopes, strings.Split(value, ",")...)
+			}
+		default:
+			http.Error(w, fmt.Sprintf("unknown query parameter '%s'", name), http.StatusBadRequest)
+			return
+		}
+	}
+
+	id, ok := me.authenticateRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// every file of the scopes may go, so the rules under them have to
+	// permit writes too
+	if id != nil {
+		subtrees := []string{"local"}
+		if opts.Common {
+			subtrees = append(subtrees, "common")
+		}
+		scopes := opts.Scopes
+		if len(scopes) == 0 {
+			scopes = []string{"/"}
+		}
+		for _, subtree := range subtrees {
+			for _, scope := range scopes {
+				path := strings.TrimRight("/" + subtree + "/" + strings.Trim(scope, "/"), "/")
+				if !me.auth.permitsTree(id, path, true) {
+					log.Printf("REST access denied for '%s' to reset %s\n", id.User, path)
+					http.Error(w, "access denied", http.StatusForbidden)
+					return
+				}
+			}
+		}
+	}
+
+	me.writeLock.Lock()
+	record, err := confs.FactoryReset(opts)
+	me.writeLock.Unlock()
+
+	if err != nil {
+		status := http.StatusInternalServerError
+		if confs.IsPathError(err) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, fmt.Sprintf("factory reset failed: %s", err.String()), status)
+		return
+	}
+
+	reply, jerr := json.MarshalIndent(struct{
+		DryRun bool `json:"dryRun"`
+		*confs.ResetRecord
+	}{opts.DryRun, record}, "", "    ")
+	if jerr != nil {
+		http.Error(w, jerr.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(reply)))
+
+	w.Write(reply)
+}
@@ -4,22 +4,62 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"io"
+	"net"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"time"
+	"sync"
+	"context"
 	"strings"
-	"net/url"
 	"net/http"
+	"crypto/tls"
 	"path/filepath"
 	"encoding/json"
 	"ostro/confs"
+	"ostro/connman"
+	"ostro/lifecycle"
+	"ostro/activation"
+	"ostro/cors"
+	"ostro/session"
+	"ostro/tlscert"
 )
 
 
+// FileHandler serves the configuration values of a drop zone subtree, by
+// default the local one, under a URL prefix. Next to the prefix it serves
+// events, export, import, reset, the network status, the OpenAPI
+// document and the TLS certificate, eg. /confs/events for /confs/local.
 type FileHandler struct {
-	addr string
-	port int
+	root string
+	subtree string
 	pattern string
 	prefix string
 	tmpdir string
+	maxBodySize int64
+	auth *Auth
+	cors *cors.Policy
+	events *broker
+	apiDoc apiDocument
+	networkStatus func() (*connman.NetworkStatus, error)
+	certificate *tlscert.Provider
+	mux *http.ServeMux
+	fallback http.Handler
+	writeLock sync.Mutex
+}
+
+type Config struct {
+	Root string		// drop zone root
+	Subtree string		// drop zone subtree of the resources, in one of the layers; "local" if empty
+	Prefix string		// URL path of the resources, like /confs/local
+	TmpDir string		// where files are prepared; <Root>/tmp if empty
+	MaxBodySize int64	// limit of PUT and PATCH bodies; DefaultMaxBodySize if 0
+	Auth *Auth		// nil disables authentication
+	CORS *cors.Policy	// DefaultPolicy(Auth) if nil
+	Events bool		// watch the drop zone and serve change events
+	Fallback http.Handler	// serves what is not REST, like the UI; 404 if nil
+	NetworkStatus func() (*connman.NetworkStatus, error)	// live status source; nil disables it
+	Certificate *tlscert.Provider	// TLS if NewServer gets no certificate files; its fingerprint is served
 }
 
 const (
@@ -27,84 +67,339 @@ const (
 	OriginAttr string = "user.origin"
 	
 	RestOriginated = "Rest"
+
+	DefaultMaxBodySize = 65536
 )
 
 
+type contextKey string
+
 var (
-	fileHandler *FileHandler = nil
-
-	allowedMethods = map[string]bool{
-		"options":true,
-		"get": true,
-		"put": true,
-		"patch": true,
-		"delete": true}
+	localConnKey = contextKey("local")
+
+	// media types of the PUT bodies, by confs type; the format of the
+	// bodies without a type or of the default one of curl -d is sniffed,
+	// and so is the one of text/plain bodies, see textType
+	bodyTypes = map[string]string{
+		"application/x-www-form-urlencoded": "",
+		"application/json": "json",
+		"application/xml": "xml",
+		"text/xml": "xml",
+		"application/x-ini": "ini",
+		"text/x-ini": "ini",
+		"text/plain": "text"}
+
+	corsMethods = []string{"GET", "HEAD", "PUT", "PATCH", "DELETE", "POST"}
+	corsHeaders = []string{"Accept", "Content-Type", "Authorization", "If-Match", "If-None-Match", "Last-Event-ID", session.CSRFHeader}
 )
 
 
-func NewFileHandler(addr string, port int, pattern, prefix, tmpdir, certFile, keyFile string) error {
-	if fileHandler == nil {
-		log.Printf("checking '%s'\n", tmpdir)
-		if err := os.MkdirAll(filepath.Dir(tmpdir), 0755); err != nil {
-			return fmt.Errorf("failed to create directory '%s': %v", tmpdir, err)
+func NewHandler(cfg Config) (*FileHandler, error) {
+	root := strings.TrimRight(cfg.Root, "/")
+	if root == "" {
+		return nil, fmt.Errorf("drop zone root is missing")
+	}
+
+	// the writes are validated by ostro/confs, under its drop zone
+	if dropZone := confs.DropZone(); root != dropZone {
+		return nil, fmt.Errorf("drop zone root '%s' is not the one of confs '%s'", root, dropZone)
+	}
+
+	subtree := strings.Trim(cfg.Subtree, "/")
+	if subtree == "" {
+		subtree = "local"
+	} else if !isLayer(strings.SplitN(subtree, "/", 2)[0]) {
+		return nil, fmt.Errorf("subtree '%s' is not in one of the layers %s", subtree, strings.Join(layers, ", "))
+	}
+
+	tmpdir := strings.TrimRight(cfg.TmpDir, "/")
+	if tmpdir == "" {
+		tmpdir = root + "/tmp"
+	}
+
+	maxBodySize := cfg.MaxBodySize
+	if maxBodySize == 0 {
+		maxBodySize = DefaultMaxBodySize
+	} else if maxBodySize < 2 {
+		return nil, fmt.Errorf("invalid body size limit %d", maxBodySize)
+	}
+
+	me := &FileHandler{
+		root: root,
+		subtree: subtree,
+		pattern: strings.TrimRight(cfg.Prefix, "/"),
+		prefix: root + "/" + subtree,
+		tmpdir: tmpdir,
+		maxBodySize: maxBodySize,
+		auth: cfg.Auth,
+		cors: cfg.CORS,
+		networkStatus: cfg.NetworkStatus,
+		certificate: cfg.Certificate,
+		fallback: cfg.Fallback,
+		mux: http.NewServeMux()}
+
+	if me.cors == nil {
+		me.cors = DefaultPolicy(cfg.Auth)
+	} else if err := me.cors.Check(); err != nil {
+		return nil, err
+	}
+
+	log.Printf("checking '%s'\n", tmpdir)
+	if err := os.MkdirAll(tmpdir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory '%s': %v", tmpdir, err)
+	}
+	if err := os.MkdirAll(me.prefix, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory '%s': %v", me.prefix, err)
+	}
+
+	if cfg.Events {
+		var err error
+		if me.events, err = newBroker(root); err != nil {
+			return nil, fmt.Errorf("failed to watch '%s': %v", root, err)
+		}
+		confs.OnReset(func(record *confs.ResetRecord) {
+			me.events.reset(record.Origin)
+		})
+	}
+
+	base := filepath.Join("/", filepath.Dir(me.pattern))
+
+	me.mux.HandleFunc(me.pattern + "/", me.serveResource)
+	me.mux.HandleFunc(filepath.Join(base, "events"), me.eventsHandler)
+	me.mux.HandleFunc(filepath.Join(base, "export"), me.exportHandler)
+	me.mux.HandleFunc(filepath.Join(base, "import"), me.importHandler)
+	me.mux.HandleFunc(filepath.Join(base, "reset"), me.resetHandler)
+	me.mux.HandleFunc(filepath.Join(base, "openapi.json"), me.openAPIHandler)
+	if me.networkStatus != nil {
+		me.mux.HandleFunc(filepath.Join(base, StatusPath), me.networkStatusHandler)
+	}
+	if me.certificate != nil {
+		me.mux.HandleFunc(filepath.Join(base, "certificate"), me.certificateHandler)
+	}
+
+	return me, nil
+}
+
+// DefaultPolicy lets any page use the REST API, unless there is
+// authentication. Then credentials are only shared with the pages of the
+// same host, like the UI.
+func DefaultPolicy(auth *Auth) *cors.Policy {
+	policy := &cors.Policy{
+		Origins: []string{cors.Any},
+		Methods: corsMethods,
+		Headers: corsHeaders,
+		Expose: []string{"ETag"}}
+
+	if auth != nil {
+		policy.Origins = []string{cors.SameHost}
+		policy.Credentials = true
+	}
+
+	return policy
+}
+
+// CORSPolicy completes a policy given by its origins with what the REST
+// API needs.
+func CORSPolicy(origins []string, credentials bool, maxAge time.Duration, expose []string) *cors.Policy {
+	return &cors.Policy{
+		Origins: origins,
+		Methods: corsMethods,
+		Headers: corsHeaders,
+		Expose: append([]string{"ETag"}, expose...),
+		Credentials: credentials,
+		MaxAge: maxAge}
+}
+
+func (me *FileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if me.fallback != nil {
+		if _, pattern := me.mux.Handler(r); pattern == "" {
+			me.fallback.ServeHTTP(w, r)
+			return
+		}
+	}
+	me.mux.ServeHTTP(w, r)
+}
+
+// serverTLSConfig returns the TLS settings of the servers, nil if the
+// defaults of the certificate files do. Client certificates are checked
+// against the CA bundle of the Auth as it is at the handshake, so a bundle
+// reloaded on SIGHUP takes effect without a restart.
+func (me *FileHandler) serverTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	var cfg *tls.Config
+
+	if me.certificate != nil && (certFile == "" || keyFile == "") {
+		cfg = &tls.Config{GetCertificate: me.certificate.GetCertificate}
+	}
+
+	if me.auth == nil || me.auth.ClientCAs() == nil {
+		return cfg, nil
+	}
+
+	if cfg == nil {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
 		}
+		cfg = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
 
-		fileHandler = &FileHandler{
-			addr: addr,
-			port: port,
-			pattern: strings.TrimRight(pattern, "/"),
-			prefix: prefix,
-			tmpdir: tmpdir}
+	base := cfg.Clone()
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		current := base.Clone()
+		current.ClientCAs = me.auth.ClientCAs()
+		return current, nil
+	}
 
-		mux := http.NewServeMux()
-		mux.HandleFunc(pattern, httpRequestHandler)
+	return cfg, nil
+}
 
-		srv := &http.Server{
-			Addr: fmt.Sprintf("%s:%d", addr, port),
-			Handler: mux,
-			MaxHeaderBytes: 4096}
-		srv.SetKeepAlivesEnabled(false)
+// NewServer serves the handler until the lifecycle ends, on the socket
+// named "rest" passed by systemd or else on the given address. Without
+// certificate files the handler's self-signed certificate is used, if any.
+// Client certificates are requested when the Auth of the handler has CAs
+// for them.
+func NewServer(addr string, port int, handler *FileHandler, certFile, keyFile string) error {
+	auth := handler.auth
+	files := certFile != "" && keyFile != ""
+
+	if auth != nil && auth.ClientCAs() != nil && !files && handler.certificate == nil {
+		return fmt.Errorf("client certificates need a TLS certificate and key")
+	}
 
-		go func(s *http.Server) {
-      if certFile == "" || keyFile == "" {
+	ln, err := activation.Take("rest", false)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Addr: fmt.Sprintf("%s:%d", addr, port),
+		Handler: handler,
+		MaxHeaderBytes: 4096,
+		BaseContext: func(net.Listener) context.Context { return lifecycle.Context() }}
+
+	if srv.TLSConfig, err = handler.serverTLSConfig(certFile, keyFile); err != nil {
+		return err
+	}
+	if srv.TLSConfig != nil && len(srv.TLSConfig.Certificates) > 0 {
+		// loaded already, for the configs of the handshakes
+		certFile, keyFile = "", ""
+	}
+	secure := files || handler.certificate != nil
+
+	lifecycle.OnShutdown(func() {
+		shutdownServer(srv)
+	})
+
+	go func(s *http.Server) {
+      switch {
+      case ln != nil && !secure:
+        log.Print(s.Serve(ln))
+      case ln != nil:
+        log.Print(s.ServeTLS(ln, certFile, keyFile))
+      case !secure:
         log.Print(s.ListenAndServe())
-      } else {
+      default:
         log.Print(s.ListenAndServeTLS(certFile, keyFile))
       }
-		}(srv)
-	} else {
-		pat := strings.TrimRight(pattern, "/")
-		if fileHandler.addr != addr || fileHandler.port != port || fileHandler.pattern != pat || fileHandler.prefix != prefix {
-			return fmt.Errorf("attempt to create multiple rest.FileHandlers")
+	}(srv)
+
+	return nil
+}
+
+// NewUnixServer serves the handler on the Unix domain socket named
+// "rest-local" passed by systemd or else on the one created at path. The
+// permissions of the socket take the place of authentication. Without
+// either socket it does nothing.
+func NewUnixServer(path string, mode os.FileMode, group string, handler *FileHandler) error {
+	ln, err := activation.Take("rest-local", false)
+	if err != nil {
+		return err
+	}
+	if ln == nil {
+		if path == "" {
+			return nil
+		}
+		if ln, err = activation.ListenUnix(path, mode, group); err != nil {
+			return err
 		}
 	}
 
+	log.Printf("REST server on '%s'\n", ln.Addr())
+
+	srv := &http.Server{
+		Handler: handler,
+		MaxHeaderBytes: 4096,
+		BaseContext: func(net.Listener) context.Context { return lifecycle.Context() },
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			return context.WithValue(ctx, localConnKey, true)
+		}}
+
+	lifecycle.OnShutdown(func() {
+		shutdownServer(srv)
+	})
+
+	go func(s *http.Server) {
+		log.Print(s.Serve(ln))
+	}(srv)
+
 	return nil
 }
 
-func httpRequestHandler(w http.ResponseWriter, r *http.Request) {
-	if len(r.URL.Query()) > 0 {
+// isLocal tells whether the request came through the Unix domain socket.
+func isLocal(r *http.Request) bool {
+	local, _ := r.Context().Value(localConnKey).(bool)
+	return local
+}
+
+func shutdownServer(srv *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("REST server shutdown: %v\n", err)
+	}
+}
+
+func (me *FileHandler) serveResource(w http.ResponseWriter, r *http.Request) {
+	if len(r.URL.Query()) > 0 && r.Method != "GET" && r.Method != "HEAD" {
 		http.Error(w, "queries are not allowed", http.StatusBadRequest)
 		return
 	}
 	
-	rp := fmt.Sprintf("%s%s", fileHandler.prefix, strings.TrimRight(strings.TrimPrefix(r.URL.Path, fileHandler.pattern), "/"))
+	rp := fmt.Sprintf("%s%s", me.prefix, strings.TrimRight(strings.TrimPrefix(r.URL.Path, me.pattern), "/"))
 	
 	log.Printf("**** rest %s %s\n", r.Method, rp)
 
-	setAccessControlFields(w, r)
+	if me.cors.Handle(w, r) {
+		return
+	}
+
+	if r.Method != "OPTIONS" && !me.authorize(me.rulePath(rp), w, r) {
+		return
+	}
+
+	if r.Method != "OPTIONS" && !me.checkNode(rp, w) {
+		return
+	}
+
+	if r.Method == "PUT" || r.Method == "PATCH" || r.Method == "DELETE" {
+		// preconditions are only meaningful if nobody writes in between
+		me.writeLock.Lock()
+		defer me.writeLock.Unlock()
+	}
 
 	switch r.Method {
 	case "OPTIONS":
 		handleOptions(rp, w, r)
-	case "GET":
-		getValues(rp, w, r)
+	case "GET", "HEAD":
+		me.getValues(rp, w, r)
 	case "PUT":
-		setValues(rp, w, r)
+		me.setValues(rp, w, r)
 	case "PATCH":
-		http.Error(w, "OK", http.StatusOK)
+		me.patchValues(rp, w, r)
 	case "DELETE":
-		http.Error(w, "OK", http.StatusOK)
+		me.deleteValues(rp, w, r)
 	default:
 		http.Error(w, fmt.Sprintf("'%s' method not supported", r.Method), http.StatusMethodNotAllowed)
 	}
@@ -114,22 +409,66 @@ func handleOptions(rp string, w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "OK", http.StatusOK)
 }
 
-func getValues(rp string, w http.ResponseWriter, r *http.Request) {
+func (me *FileHandler) getValues(rp string, w http.ResponseWriter, r *http.Request) {
 	var (
 		err error
 		reply []byte
 	)
 
+	opts, err := parseReadOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !me.preconditionsHold(rp, opts, w, r) {
+		return
+	}
+	me.setETag(rp, opts, w)
+
+	values, err := me.readValues(rp, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if opts.format == "json" {
+		reply, err = json.MarshalIndent(values, "", "    ")
+	} else {
+		reply, err = confs.Marshal(me.rulePath(rp), opts.format, values, confs.Pretty)
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		if confs.IsIniError(err) {
+			// rather than an INI body reading back as other values
+			status = http.StatusNotAcceptable
+		}
+		http.Error(w, fmt.Sprintf("failed to produce %s: %v", strings.ToUpper(opts.format), err), status)
+		return
+	}
+	
+	
+	w.Header().Set("Content-Type", contentTypes[opts.format])
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(reply)))
+
+	if r.Method == "GET" {
+		w.Write(reply)
+	}
+}
+
+func (me *FileHandler) readValues(rp string, opts *readOptions) (map[string]interface{}, error) {
 	values := make(map[string]interface{})
 
-	for _, tree := range []string{"factory", "common", "local"} {
+	for i, path := range me.treePaths(rp) {
 		treeValues := make(map[string]interface{})
-		path := strings.Replace(rp, "local", tree, 1)
+
+		if !opts.selected(layers[i]) {
+			continue
+		}
 		
-		if err = traverseDirectoryTree(path, treeValues); err != nil {
+		if err := traverseDirectoryTree(path, treeValues, opts.annotator(layers[i])); err != nil {
 			if !os.IsNotExist(err) {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
+				return nil, err
 			}
 		}
 
@@ -141,53 +480,51 @@ func getValues(rp string, w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	if reply, err = json.MarshalIndent(values, "", "    "); err != nil {
-		http.Error(w, fmt.Sprintf("failed to produce JSON: %v", err),
-			http.StatusInternalServerError);
-		return
-	}
-	
-	
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(reply)))
+	opts.shape(values, 0)
 
-	w.Write(reply)
+	return values, nil
 }
 
-func setValues(rp string, w http.ResponseWriter, r *http.Request) {
+func (me *FileHandler) setValues(rp string, w http.ResponseWriter, r *http.Request) {
 	var (
 		newValues, values map[string]interface{}
 		err error
 	)
 	
-	if r.ContentLength > 65536 {
-		http.Error(w, "Excessive request content", http.StatusBadRequest)
-		return
+	typ := ""
+	if mediaType := r.Header.Get("Content-Type"); mediaType != "" {
+		mediaType = strings.ToLower(strings.TrimSpace(strings.Split(mediaType, ";")[0]))
+		var found bool
+		if typ, found = bodyTypes[mediaType]; !found {
+			http.Error(w, fmt.Sprintf("unsupported content type '%s'", mediaType),
+				http.StatusUnsupportedMediaType)
+			return
+		}
 	}
 
-	size := int(r.ContentLength)
+	if !me.preconditionsHold(rp, nil, w, r) {
+		return
+	}
 
-	if size < 2 {
-		http.Error(w, "Undersized request content", http.StatusBadRequest)
+	content, ok := me.readContent(w, r)
+	if !ok {
 		return
 	}
-	
-	content := make([]byte, size)
-	if len, err := r.Body.Read(content); (err != nil && err != io.EOF) || len != size {
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to read request content: %v", err),
-				http.StatusInternalServerError)
-		} else {
-			http.Error(w, "Failed to read request content: partial read",
-				http.StatusInternalServerError)
+
+	if typ == "text" {
+		typ = textType(content)
+	}
+
+	if newValues, err = confs.UnmarshalType(me.rulePath(rp), typ, content); err != nil {
+		status := http.StatusBadRequest
+		if confs.IsSizeError(err) {
+			status = http.StatusRequestEntityTooLarge
 		}
+		http.Error(w, fmt.Sprintf("malformed request content: %v", err), status)
 		return
 	}
 
-	newValues = make(map[string]interface{})
-	if err = json.Unmarshal(content, &newValues); err != nil {
-		http.Error(w, fmt.Sprintf("malformed request content: %v", err),
-			http.StatusBadRequest)
+	if rp, ok = me.writablePath(rp, w); !ok {
 		return
 	}
 
@@ -206,57 +543,62 @@ func setValues(rp string, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = writeFile(rp, values); err != nil {
+	if err = me.writeFile(rp, values); err != nil {
 		http.Error(w, fmt.Sprintf("writing to '%s' failed: %v", rp, err),
-			http.StatusInternalServerError)
+			writeErrorStatus(err))
 		return
 	}
 	
+	me.setETag(rp, defaultReadOptions(), w)
 	http.Error(w, "OK", http.StatusOK)
 }
 
-func allowOrigin(host, origin string) bool {
-	return true
+// textType tells the format of a text/plain body. JSON and XML, which
+// clients send as text/plain to avoid a preflight, are sniffed like an
+// untyped body; anything else is INI, like the text/plain replies.
+func textType(content []byte) string {
+	trimmed := bytes.TrimLeft(content, " \t\n\r\f")
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '<') {
+		return ""
+	}
+	return "ini"
 }
 
-func setAllowOrigin(w http.ResponseWriter, r *http.Request) {
-	host := r.Host
-	origin := r.Header.Get("Origin")
-	
-	if origURL, err := url.Parse(origin); err == nil {
-		origHost := origURL.Host
+// readContent reads the request body up to the size limit, whether its
+// length is given or it comes chunked.
+func (me *FileHandler) readContent(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if r.ContentLength > me.maxBodySize {
+		http.Error(w, "Excessive request content", http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
 
-		if host != "" && origHost != "" && allowOrigin(host, origHost) {
-			w.Header().Set("Access-Control-Allow-Origin", origin)
+	content, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, me.maxBodySize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Excessive request content", http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, fmt.Sprintf("Failed to read request content: %v", err),
+				http.StatusBadRequest)
 		}
+		return nil, false
 	}
-}
 
-func setAllowMethods(w http.ResponseWriter, r *http.Request) {
-	request := strings.Replace(r.Header.Get("Access-Control-Request-Method"), " ", "", -1)
-	allow := []string{}
-
-	if request != "" {
-		for _, method := range strings.Split(request, ",") {
-			if allowedMethods[strings.ToLower(method)] {
-				allow = append(allow, method)
-			}
-		}
-		if len(allow) > 0 {
-			w.Header().Set("Access-Control-Allow-Methods", strings.Join(allow, ", "))
-		}
+	if len(content) < 2 {
+		http.Error(w, "Undersized request content", http.StatusBadRequest)
+		return nil, false
 	}
+
+	return content, true
 }
-	
-func setAllowHeaders(w http.ResponseWriter, r *http.Request) {
-	request := r.Header.Get("Access-Control-Request-Headers")
-	if request != "" {
-		w.Header().Set("Access-Control-Allow-Headers", request)
+
+func writeErrorStatus(err error) int {
+	if err == ownerError {
+		return http.StatusConflict
 	}
+	return http.StatusInternalServerError
 }
 
-func setAccessControlFields(w http.ResponseWriter, r *http.Request) {
-	setAllowOrigin(w,r)
-	setAllowMethods(w,r)
-	setAllowHeaders(w,r)
+func (me *FileHandler) rulePath(rp string) string {
+	return "/" + me.subtree + strings.TrimPrefix(rp, me.prefix)
 }
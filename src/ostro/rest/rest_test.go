@@ -0,0 +1,197 @@
+package rest
+
+import (
+	"os"
+	"flag"
+	"errors"
+	"testing"
+	"net/http"
+	"net/http/httptest"
+	"ostro/cors"
+	"ostro/confs"
+	"ostro/connman"
+)
+
+// testRoot makes a temporary drop zone the one of confs, which NewHandler
+// insists on, with empty definitions.
+func testRoot(t *testing.T) string {
+	root := t.TempDir()
+
+	savedRoot, savedDefs := confs.DropZone(), confs.DefinitionRoot()
+	flag.Set("drop-zone", root)
+	flag.Set("definition-root", t.TempDir())
+	t.Cleanup(func() {
+		flag.Set("drop-zone", savedRoot)
+		flag.Set("definition-root", savedDefs)
+	})
+
+	return root
+}
+
+func TestConfigDefaults(t *testing.T) {
+	root := testRoot(t)
+
+	handler, err := NewHandler(Config{Root: root + "/", Prefix: "/confs/local/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if handler.subtree != "local" || handler.prefix != root + "/local" || handler.pattern != "/confs/local" {
+		t.Errorf("resources: subtree '%s', prefix '%s', pattern '%s'", handler.subtree, handler.prefix, handler.pattern)
+	}
+	if handler.tmpdir != root + "/tmp" {
+		t.Errorf("tmp dir '%s'", handler.tmpdir)
+	}
+	if handler.maxBodySize != DefaultMaxBodySize {
+		t.Errorf("body size limit %d", handler.maxBodySize)
+	}
+	for _, dir := range []string{handler.tmpdir, handler.prefix} {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			t.Errorf("directory '%s' not created: %v", dir, err)
+		}
+	}
+	if len(handler.cors.Origins) != 1 || handler.cors.Origins[0] != cors.Any || handler.cors.Credentials {
+		t.Errorf("policy without authentication: %v, credentials %v", handler.cors.Origins, handler.cors.Credentials)
+	}
+
+	handler, err = NewHandler(Config{Root: root, Subtree: "common/device", Prefix: "/confs/device", Auth: newTestAuth(t, "")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if handler.prefix != root + "/common/device" {
+		t.Errorf("prefix '%s' of subtree 'common/device'", handler.prefix)
+	}
+	if len(handler.cors.Origins) != 1 || handler.cors.Origins[0] != cors.SameHost || !handler.cors.Credentials {
+		t.Errorf("policy with authentication: %v, credentials %v", handler.cors.Origins, handler.cors.Credentials)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	root := testRoot(t)
+
+	for _, cfg := range []Config{
+		{Prefix: "/confs/local"},
+		{Root: t.TempDir(), Prefix: "/confs/local"},
+		{Root: root, Subtree: "other", Prefix: "/confs/local"},
+		{Root: root, Subtree: "/", Prefix: "/confs/local", MaxBodySize: 1},
+		{Root: root, Prefix: "/confs/local", CORS: &cors.Policy{Origins: []string{cors.Any}, Credentials: true}},
+	} {
+		if _, err := NewHandler(cfg); err == nil {
+			t.Errorf("%+v accepted", cfg)
+		}
+	}
+}
+
+func TestRouting(t *testing.T) {
+	root := testRoot(t)
+
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Fallback", "ui")
+		w.WriteHeader(http.StatusTeapot)
+	})
+	status := func() (*connman.NetworkStatus, error) {
+		return nil, errors.New("no connman")
+	}
+
+	handler, err := NewHandler(Config{Root: root, Prefix: "/confs/local", Fallback: fallback, NetworkStatus: status})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct{
+		path string
+		rest bool
+		status int
+	}{
+		{"/confs/local/network", true, 0},
+		{"/confs/events", true, http.StatusNotFound},
+		{"/confs/export", true, 0},
+		{"/confs/openapi.json", true, http.StatusOK},
+		{"/confs/status/network", true, http.StatusServiceUnavailable},
+		{"/confs/certificate", false, http.StatusTeapot},
+		{"/confs/index.html", false, http.StatusTeapot},
+		{"/", false, http.StatusTeapot},
+	} {
+		r := httptest.NewRequest("GET", c.path, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if rest := w.Header().Get("X-Fallback") == ""; rest != c.rest {
+			t.Errorf("%s served by the REST handler: %v", c.path, rest)
+		}
+		if c.status != 0 && w.Code != c.status {
+			t.Errorf("%s: got %d, expected %d", c.path, w.Code, c.status)
+		}
+	}
+}
+
+func TestNoFallback(t *testing.T) {
+	root := testRoot(t)
+
+	handler, err := NewHandler(Config{Root: root, Prefix: "/api/confs/local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/confs/index.html", "/api/confs/status/network", "/api/confs/certificate"} {
+		r := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: got %d", path, w.Code)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/api/confs/openapi.json", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("openapi.json under the base: got %d", w.Code)
+	}
+}
+
+// The UI on :8080 uses the REST server on :4984 without authentication;
+// the answer must let any page read it, without credentials.
+func TestDefaultPolicyOtherPort(t *testing.T) {
+	root := testRoot(t)
+
+	handler, err := NewHandler(Config{Root: root, Prefix: "/confs/local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "http://device:4984/confs/local/net", nil)
+	r.Header.Set("Origin", "http://device:8080")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if origin := w.Header().Get("Access-Control-Allow-Origin"); origin != cors.Any {
+		t.Errorf("origin '%s' allowed", origin)
+	}
+	if credentials := w.Header().Get("Access-Control-Allow-Credentials"); credentials != "" {
+		t.Errorf("credentials allowed with '*'")
+	}
+}
+
+func TestTextPlainBodies(t *testing.T) {
+	for _, c := range []struct{
+		content string
+		typ string
+	}{
+		{` {"ssid": "home"}`, ""},
+		{"<?xml version=\"1.0\"?>\n<wifi><ssid>home</ssid></wifi>", ""},
+		{"ssid = home\n", "ini"},
+		{"[wifi]\nssid = home\n", "ini"},
+	} {
+		if typ := textType([]byte(c.content)); typ != c.typ {
+			t.Errorf("'%s': got type '%s', expected '%s'", c.content, typ, c.typ)
+		}
+	}
+
+	values, err := confs.UnmarshalType("/local/wifi", textType([]byte(`{"ssid": "home"}`)), []byte(`{"ssid": "home"}`))
+	if err != nil || values["ssid"] != "home" {
+		t.Errorf("JSON sent as text/plain: got %v, %v", values, err)
+	}
+}
@@ -0,0 +1,68 @@
+package rest
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"encoding/json"
+	"ostro/connman"
+)
+
+// StatusPath is the access rule path of the live network status.
+const StatusPath = "/status/network"
+
+// SystemNetworkStatus reads the network status from connman on the
+// system bus, which is connected at the first request that needs it.
+func SystemNetworkStatus() (*connman.NetworkStatus, error) {
+	srv, err := connman.NewServer()
+	if err != nil {
+		return nil, err
+	}
+
+	return srv.GetNetworkStatus()
+}
+
+func (me *FileHandler) networkStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if me.cors.Handle(w, r) {
+		return
+	}
+
+	if r.Method == "OPTIONS" {
+		http.Error(w, "OK", http.StatusOK)
+		return
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, fmt.Sprintf("'%s' method not supported", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if len(r.URL.Query()) > 0 {
+		http.Error(w, "no query parameters are accepted", http.StatusBadRequest)
+		return
+	}
+
+	if !me.authorize(StatusPath, w, r) {
+		return
+	}
+
+	status, err := me.networkStatus()
+	if err != nil {
+		log.Printf("reading network status failed: %v\n", err)
+		http.Error(w, fmt.Sprintf("network status is not available: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	reply, err := json.MarshalIndent(status, "", "    ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(reply)))
+	w.Header().Set("Cache-Control", "no-store")
+
+	if r.Method == "GET" {
+		w.Write(reply)
+	}
+}
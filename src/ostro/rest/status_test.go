@@ -0,0 +1,54 @@
+package rest
+
+import (
+	"testing"
+	"net/http"
+	"net/http/httptest"
+	"encoding/json"
+	"ostro/connman"
+)
+
+func TestNetworkStatus(t *testing.T) {
+	root := testRoot(t)
+
+	live := &connman.NetworkStatus{
+		State: "online",
+		Technologies: []connman.TechnologyStatus{{Type: "wifi", Name: "WiFi", Powered: true, Connected: true}},
+		Services: []connman.ServiceStatus{{Id: "wifi_home", Type: "wifi", Name: "home", State: "online", Strength: 72}}}
+	status := func() (*connman.NetworkStatus, error) {
+		return live, nil
+	}
+
+	handler, err := NewHandler(Config{Root: root, Prefix: "/confs/local", NetworkStatus: status, Auth: newTestAuth(t, StatusPath + " admin admin\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(token, query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/confs" + StatusPath + query, nil)
+		r.Header.Set("Authorization", "Bearer " + token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := get("user-token", ""); w.Code != http.StatusForbidden {
+		t.Errorf("user without a rule: got %d", w.Code)
+	}
+	if w := get("admin-token", "?scan=1"); w.Code != http.StatusBadRequest {
+		t.Errorf("query: got %d", w.Code)
+	}
+
+	w := get("admin-token", "")
+	if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("got %d, Cache-Control '%s'", w.Code, w.Header().Get("Cache-Control"))
+	}
+
+	reply := &connman.NetworkStatus{}
+	if err := json.Unmarshal(w.Body.Bytes(), reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.State != "online" || len(reply.Technologies) != 1 || len(reply.Services) != 1 || reply.Services[0].Strength != 72 {
+		t.Errorf("got %+v", reply)
+	}
+}
@@ -0,0 +1,187 @@
+package session
+
+import (
+	"fmt"
+	"bufio"
+	"errors"
+	"strings"
+	"strconv"
+	"sync"
+	"os"
+	"crypto/subtle"
+	"encoding/base64"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// hash of the users who may only log in with a client certificate
+	NoPassword = "!"
+
+	scryptPrefix = "$scrypt$"
+)
+
+var (
+	PasswordError = errors.New("invalid user or password")
+	hashError = errors.New("unsupported password hash")
+
+	dummyOnce sync.Once
+	dummyHash []byte
+)
+
+// Users are read from a password file of lines like
+// '<user>:<hash>:<role>[,<role>...]', with '-' for no roles. Hashes are
+// bcrypt ones, eg. from htpasswd -B, or scrypt ones of the form
+// $scrypt$ln=<log2 N>,r=<r>,p=<p>$<salt>$<key> with unpadded base64.
+type Users struct {
+	hashes map[string]string
+	roles map[string][]string
+}
+
+func ReadUsers(path string) (*Users, error) {
+	me := &Users{hashes: map[string]string{}, roles: map[string][]string{}}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	for lineno := 1;  scanner.Scan();  lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		entry := strings.SplitN(strings.Join(strings.Fields(line), ""), ":", 3)
+		if len(entry) != 3 || entry[0] == "" {
+			return nil, fmt.Errorf("%s:%d: expected '<user>:<hash>:<roles>'", path, lineno)
+		}
+		if entry[1] != NoPassword {
+			if err := CheckHash(entry[1]); err != nil {
+				return nil, fmt.Errorf("%s:%d: user '%s': %v", path, lineno, entry[0], err)
+			}
+		}
+
+		me.hashes[entry[0]] = entry[1]
+		me.roles[entry[0]] = SplitRoles(entry[2])
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return me, nil
+}
+
+// SplitRoles splits a comma separated role list; '-' is none.
+func SplitRoles(list string) []string {
+	roles := []string{}
+
+	if list == "-" {
+		return roles
+	}
+	for _, role := range strings.Split(list, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+
+	return roles
+}
+
+// Roles returns the roles of a known user, who may be password-less.
+func (me *Users) Roles(user string) ([]string, bool) {
+	roles, found := me.roles[user]
+	return roles, found
+}
+
+// Verify returns the roles of the user if the password is right.
+func (me *Users) Verify(user, password string) ([]string, error) {
+	hash, found := me.hashes[user]
+	if !found || hash == NoPassword {
+		// as slow as a wrong password, not to tell which users exist
+		dummyOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("-"), bcrypt.DefaultCost)
+		})
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, PasswordError
+	}
+
+	if err := CheckPassword(hash, password); err != nil {
+		return nil, PasswordError
+	}
+
+	return me.roles[user], nil
+}
+
+// CheckHash tells whether the hash is of a supported kind.
+func CheckHash(hash string) error {
+	if strings.HasPrefix(hash, scryptPrefix) {
+		_, _, _, _, _, err := parseScrypt(hash)
+		return err
+	}
+
+	_, err := bcrypt.Cost([]byte(hash))
+	return err
+}
+
+// CheckPassword compares the password with a bcrypt or scrypt hash.
+func CheckPassword(hash, password string) error {
+	if !strings.HasPrefix(hash, scryptPrefix) {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	}
+
+	n, r, p, salt, key, err := parseScrypt(hash)
+	if err != nil {
+		return err
+	}
+
+	derived, err := scrypt.Key([]byte(password), salt, n, r, p, len(key))
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(derived, key) != 1 {
+		return PasswordError
+	}
+
+	return nil
+}
+
+func parseScrypt(hash string) (int, int, int, []byte, []byte, error) {
+	parts := strings.Split(strings.TrimPrefix(hash, scryptPrefix), "$")
+	if len(parts) != 3 {
+		return 0, 0, 0, nil, nil, hashError
+	}
+
+	params := map[string]int{}
+	for _, param := range strings.Split(parts[0], ",") {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			return 0, 0, 0, nil, nil, hashError
+		}
+		value, err := strconv.Atoi(kv[1])
+		if err != nil || value < 1 {
+			return 0, 0, 0, nil, nil, hashError
+		}
+		params[kv[0]] = value
+	}
+
+	ln, r, p := params["ln"], params["r"], params["p"]
+	if ln < 1 || ln > 30 || r < 1 || p < 1 {
+		return 0, 0, 0, nil, nil, hashError
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil || len(salt) == 0 {
+		return 0, 0, 0, nil, nil, hashError
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil || len(key) < 16 {
+		return 0, 0, 0, nil, nil, hashError
+	}
+
+	return 1 << uint(ln), r, p, salt, key, nil
+}
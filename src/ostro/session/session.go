@@ -0,0 +1,201 @@
+package session
+
+import (
+	"log"
+	"sync"
+	"time"
+	"errors"
+	"net/http"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"ostro/lifecycle"
+)
+
+const (
+	CookieName = "confs-session"
+	CSRFHeader = "X-CSRF-Token"
+	CSRFField = "csrf_token"
+
+	DefaultTTL = 30 * time.Minute
+)
+
+var (
+	CSRFError = errors.New("missing or invalid CSRF token")
+	// AccessError tells the session may not access a resource.
+	AccessError = errors.New("access denied")
+)
+
+type Config struct {
+	PasswordFile string		// see Users
+	TTL time.Duration		// idle time after which a session ends; DefaultTTL if 0
+	Secure bool			// cookies for HTTPS only, even if the request came in plain
+}
+
+type Session struct {
+	ID string
+	User string
+	Roles []string
+	CSRFToken string		// to be sent back with every state changing request
+	Expires time.Time
+}
+
+// Manager keeps the sessions of the logged in users in memory, so they
+// end with the process. The password file is reread on SIGHUP.
+type Manager struct {
+	sync.Mutex
+	config Config
+	users *Users
+	sessions map[string]*Session
+}
+
+func NewManager(config Config) (*Manager, error) {
+	if config.TTL <= 0 {
+		config.TTL = DefaultTTL
+	}
+
+	users, err := ReadUsers(config.PasswordFile)
+	if err != nil {
+		return nil, err
+	}
+
+	me := &Manager{config: config, users: users, sessions: map[string]*Session{}}
+
+	lifecycle.OnReload(func() {
+		users, err := ReadUsers(config.PasswordFile)
+		if err != nil {
+			log.Printf("keep using the previous UI users: %v\n", err)
+			return
+		}
+		me.Lock()
+		me.users = users
+		me.Unlock()
+		log.Printf("reloaded UI users\n")
+	})
+
+	return me, nil
+}
+
+func randomToken() string {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// Login starts a session if the password is right.
+func (me *Manager) Login(user, password string) (*Session, error) {
+	me.Lock()
+	users := me.users
+	me.Unlock()
+
+	roles, err := users.Verify(user, password)
+	if err != nil {
+		log.Printf("UI login failed for '%s'\n", user)
+		return nil, err
+	}
+
+	s := &Session{
+		ID: randomToken(),
+		User: user,
+		Roles: roles,
+		CSRFToken: randomToken(),
+		Expires: time.Now().Add(me.config.TTL)}
+
+	me.Lock()
+	me.expire()
+	me.sessions[s.ID] = s
+	me.Unlock()
+
+	log.Printf("UI login of '%s'\n", user)
+
+	return s, nil
+}
+
+// Lookup returns the live session of the request's cookie, if any, and
+// extends it.
+func (me *Manager) Lookup(r *http.Request) *Session {
+	cookie, err := r.Cookie(CookieName)
+	if err != nil || cookie.Value == "" {
+		return nil
+	}
+
+	me.Lock()
+	defer me.Unlock()
+
+	s, found := me.sessions[cookie.Value]
+	if !found {
+		return nil
+	}
+
+	now := time.Now()
+	if now.After(s.Expires) {
+		delete(me.sessions, s.ID)
+		return nil
+	}
+	s.Expires = now.Add(me.config.TTL)
+
+	// a copy, as the session may be extended or ended meanwhile
+	copied := *s
+	return &copied
+}
+
+// Logout ends the session of the request.
+func (me *Manager) Logout(r *http.Request) {
+	if cookie, err := r.Cookie(CookieName); err == nil {
+		me.Lock()
+		if s, found := me.sessions[cookie.Value]; found {
+			log.Printf("UI logout of '%s'\n", s.User)
+			delete(me.sessions, cookie.Value)
+		}
+		me.Unlock()
+	}
+}
+
+func (me *Manager) expire() {
+	now := time.Now()
+	for id, s := range me.sessions {
+		if now.After(s.Expires) {
+			delete(me.sessions, id)
+		}
+	}
+}
+
+// SetCookie hands the session to the browser. The cookie is valid as long
+// as the browser runs; the session itself expires on the server.
+func (me *Manager) SetCookie(w http.ResponseWriter, r *http.Request, s *Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name: CookieName,
+		Value: s.ID,
+		Path: "/",
+		HttpOnly: true,
+		Secure: me.config.Secure || r.TLS != nil,
+		SameSite: http.SameSiteLaxMode})
+}
+
+func (me *Manager) ClearCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name: CookieName,
+		Value: "",
+		Path: "/",
+		MaxAge: -1,
+		HttpOnly: true,
+		Secure: me.config.Secure || r.TLS != nil,
+		SameSite: http.SameSiteLaxMode})
+}
+
+// CheckCSRF compares the token of the request, sent in the X-CSRF-Token
+// header or the csrf_token form field, with the one of the session.
+func CheckCSRF(r *http.Request, s *Session) error {
+	token := r.Header.Get(CSRFHeader)
+	if token == "" {
+		token = r.PostFormValue(CSRFField)
+	}
+
+	if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.CSRFToken)) != 1 {
+		return CSRFError
+	}
+
+	return nil
+}
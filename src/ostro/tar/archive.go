@@ -0,0 +1,193 @@
+package tar
+
+import (
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"archive/tar"
+	"path/filepath"
+	"golang.org/x/sys/unix"
+	"ostro/confs"
+	"ostro/lifecycle"
+)
+
+const (
+	Applied = "applied"
+	Valid   = "valid"
+	Failed  = "failed"
+	Skipped = "skipped"
+
+	xattrRecord = "SCHILY.xattr."
+)
+
+var (
+	exportedAttrs = []string{confs.OriginAttr, confs.HashAttr}
+)
+
+type ExtractOptions struct {
+	Prefix string
+	Origin string
+	DryRun bool
+	Accept func(confPath string) error	// extra check of every file, may be nil
+}
+
+type Result struct {
+	Path string `json:"path"`
+	Status string `json:"status"`
+	Error string `json:"error,omitempty"`
+}
+
+// Extract applies the files of a tar stream as configuration fragments.
+// The error is only set when the archive itself can't be read; the
+// problems of the individual files are in the results.
+func Extract(r io.Reader, opts ExtractOptions) ([]Result, error) {
+	results := []Result{}
+	reader := tar.NewReader(r)
+
+	for {
+		if lifecycle.Context().Err() != nil {
+			return results, stopError
+		}
+
+		hdr, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return results, err
+		}
+		info := hdr.FileInfo()
+
+		if info.IsDir() {
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			confs.Debugf("Skipping non-regular file '%s'\n", hdr.Name);
+			results = append(results, Result{Path: hdr.Name, Status: Skipped})
+			continue
+		}
+
+		results = append(results, extractFile(reader, hdr, opts))
+	}
+
+	return results, nil
+}
+
+func extractFile(reader *tar.Reader, hdr *tar.Header, opts ExtractOptions) Result {
+	confPath, err := getConfPath(hdr.Name, opts.Prefix)
+	if err != nil {
+		return failure(hdr.Name, err)
+	}
+
+	if opts.Accept != nil {
+		if err := opts.Accept(confPath); err != nil {
+			return failure(confPath, err)
+		}
+	}
+
+	content, err := getFileContent(reader, hdr.Name, int(hdr.Size))
+	if err != nil {
+		return failure(confPath, err)
+	}
+
+	frag, err := confs.NewConfFragment(confPath, opts.Origin, content)
+	if err != nil {
+		return failure(confPath, err)
+	}
+
+	if opts.DryRun {
+		if err := frag.CheckDropZone(); err != nil {
+			return failure(confPath, err)
+		}
+		return Result{Path: confPath, Status: Valid}
+	}
+
+	if err := frag.WriteDropZone(); err != nil {
+		return failure(confPath, err)
+	}
+
+	return Result{Path: confPath, Status: Applied}
+}
+
+func failure(path string, err error) Result {
+	confs.Debugf("failed to extract '%s': %v", path, err)
+
+	return Result{Path: path, Status: Failed, Error: err.Error()}
+}
+
+// Export writes the drop zone files under confPath, like /local/network,
+// to a tar stream in the layout Extract and tarconfs consume, ie. with
+// names like ./local/network/wifi. The origin and hash go along as PAX
+// extended attributes. Files whose path accept, if not nil, refuses are
+// left out.
+func Export(w io.Writer, dropZone, confPath string, accept func(confPath string) bool) error {
+	root := strings.TrimRight(dropZone, "/")
+	files := []string{}
+
+	err := filepath.Walk(root + confPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && path == root + "/tmp" {
+			return filepath.SkipDir
+		}
+		if info.Mode().IsRegular() && (accept == nil || accept(strings.TrimPrefix(path, root))) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(files)
+
+	writer := tar.NewWriter(w)
+
+	for _, path := range files {
+		if err := exportFile(writer, path, "." + strings.TrimPrefix(path, root)); err != nil {
+			return err
+		}
+	}
+
+	return writer.Close()
+}
+
+func exportFile(writer *tar.Writer, path, name string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	hdr.Uname = ""
+	hdr.Gname = ""
+	hdr.Format = tar.FormatPAX
+	hdr.PAXRecords = map[string]string{}
+
+	for _, attr := range exportedAttrs {
+		buf := make([]byte, 64)
+		if size, err := unix.Getxattr(path, attr, buf); err == nil {
+			hdr.PAXRecords[xattrRecord + attr] = string(buf[:size])
+		}
+	}
+
+	if err := writer.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	_, err = io.CopyN(writer, file, info.Size())
+
+	return err
+}
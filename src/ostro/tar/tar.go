@@ -21,6 +21,7 @@ var (
 	sizeError   = errors.New("file exceeds 64kB")
 	lengthError = errors.New("only part could be written")
 	originError = errors.New("not Tar originated")
+	stopError   = errors.New("shutting down")
 )
 
 type Confs struct {
@@ -84,47 +85,24 @@ func (me *Confs) ExtractFiles(pattern string) bool {
 		return false
 	}
 
-	reader := tar.NewReader(me.file)
+	results, err := Extract(me.file, ExtractOptions{Prefix: me.prefix, Origin: TarOriginated})
 
-	for {
-		var confPath string
+	me.close()
 
-		hdr, err := reader.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
+	if err != nil {
+		if err == stopError {
+			confs.Errorf("extraction of '%s' interrupted\n", me.path)
+		} else {
 			confs.Errorf("failed to read '%s': %v\n", me.path, err)
-			me.close()
-			return false
-		}
-		info := hdr.FileInfo()
-
-		if confPath, err = me.getConfPath(hdr.Name); err != nil {
-			failed = printExtractError(failed, err, hdr)
-			continue
 		}
+		return false
+	}
 
-		switch {
-		case info.Mode().IsRegular():
-			if content, err := getFileContent(reader, hdr.Name, int(hdr.Size)); err != nil {
-				failed = printExtractError(failed, err, hdr)
-			} else {
-				frag, err := confs.NewConfFragment(confPath, TarOriginated, content)
-				if err != nil {
-					failed = printExtractError(failed, err, hdr)
-				}
-				if err := frag.WriteDropZone(); err != nil {
-					failed = printExtractError(failed, err, hdr)
-				}
-			}
-		case info.IsDir():
-		default:
-			confs.Debugf("Skipping non-regular file '%s'\n", hdr.Name);
+	for _, r := range results {
+		if r.Status == Failed {
+			failed = append(failed, path.Base(r.Path))
 		}
 	}
-
-	me.close()
 	
 	if len(failed) > 0 {
 		confs.Errorf("failed to extract some files from '%s': %s\n",
@@ -135,12 +113,12 @@ func (me *Confs) ExtractFiles(pattern string) bool {
 	return true
 }
 
-func (me *Confs) getConfPath(name string) (string, error) {
-	if !strings.HasPrefix(name, me.prefix) {
+func getConfPath(name, prefix string) (string, error) {
+	if !strings.HasPrefix(name, prefix) {
 		return "", prefixError
 	}
 
-	confPath := strings.TrimPrefix(name, me.prefix)
+	confPath := strings.TrimPrefix(name, prefix)
 
 	if !confs.IsValidPath(confPath) {
 		return "", pathError
@@ -158,7 +136,7 @@ func getFileContent(reader *tar.Reader, name string, size int) ([]byte, error) {
 
 	var content = make([]byte, size)
 
-	if read, err := reader.Read(content);  read != size || err != nil {
+	if read, err := io.ReadFull(reader, content);  read != size || err != nil {
 		if err != nil {
 			return noContent, err
 		} else {
@@ -168,12 +146,3 @@ func getFileContent(reader *tar.Reader, name string, size int) ([]byte, error) {
 
 	return content, nil
 }
-
-
-func printExtractError(failed []string, err error, hdr *tar.Header) []string {
-	name := path.Base(hdr.Name)
-
-	confs.Debugf("failed to extract '%s': %v", name, err)
-
-	return append(failed, name)
-}
@@ -0,0 +1,371 @@
+package tlscert
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+	"strings"
+	"math/big"
+	"io/ioutil"
+	"path/filepath"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/base64"
+	"encoding/pem"
+	"ostro/lifecycle"
+)
+
+const (
+	KeyFile = "key.pem"
+	CertFile = "cert.pem"
+
+	DefaultValidity = 365 * 24 * time.Hour
+	DefaultRenewBefore = 30 * 24 * time.Hour
+
+	// how often the expiry and the addresses of the device are checked
+	checkInterval = time.Hour
+)
+
+type Config struct {
+	Dir string			// directory of KeyFile and CertFile
+	Hosts []string			// names and addresses to cover besides the hostname and the current IPs
+	Validity time.Duration		// DefaultValidity if 0
+	RenewBefore time.Duration	// DefaultRenewBefore if 0
+}
+
+// Info describes the certificate for pinning it in the clients.
+type Info struct {
+	Subject string			`json:"subject"`
+	DNSNames []string		`json:"dnsNames"`
+	IPAddresses []string		`json:"ipAddresses"`
+	NotBefore time.Time		`json:"notBefore"`
+	NotAfter time.Time		`json:"notAfter"`
+	Fingerprint string		`json:"sha256Fingerprint"`
+	PublicKeyPin string		`json:"publicKeyPin"`
+}
+
+// Provider serves a self-signed certificate of a device-unique ECDSA key.
+// The key is created once and kept, so the public key pin stays valid;
+// the certificate is issued again before it expires or when it no longer
+// covers the hostname and the addresses of the device. The servers pick
+// up a new certificate through GetCertificate without restarting.
+type Provider struct {
+	sync.RWMutex
+	config Config
+	key *ecdsa.PrivateKey
+	cert *tls.Certificate
+}
+
+func NewProvider(config Config) (*Provider, error) {
+	if config.Dir == "" {
+		return nil, fmt.Errorf("no directory for the TLS key and certificate")
+	}
+	if config.Validity <= 0 {
+		config.Validity = DefaultValidity
+	}
+	if config.RenewBefore <= 0 || config.RenewBefore >= config.Validity {
+		config.RenewBefore = config.Validity / 10
+		if config.RenewBefore > DefaultRenewBefore {
+			config.RenewBefore = DefaultRenewBefore
+		}
+	}
+
+	if err := os.MkdirAll(config.Dir, 0700); err != nil {
+		return nil, err
+	}
+
+	key, err := loadKey(filepath.Join(config.Dir, KeyFile))
+	if err != nil {
+		return nil, err
+	}
+
+	me := &Provider{config: config, key: key}
+
+	if cert, err := tls.LoadX509KeyPair(me.path(CertFile), me.path(KeyFile)); err == nil {
+		me.cert = &cert
+	} else if !os.IsNotExist(err) {
+		log.Printf("replacing TLS certificate: %v\n", err)
+	}
+
+	if err := me.check(); err != nil {
+		return nil, err
+	}
+
+	go me.watch()
+
+	return me, nil
+}
+
+func (me *Provider) path(name string) string {
+	return filepath.Join(me.config.Dir, name)
+}
+
+// GetCertificate is for tls.Config.
+func (me *Provider) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	me.RLock()
+	defer me.RUnlock()
+
+	return me.cert, nil
+}
+
+// Info describes the current certificate.
+func (me *Provider) Info() *Info {
+	me.RLock()
+	cert := me.cert
+	me.RUnlock()
+
+	leaf := cert.Leaf
+	if leaf == nil {
+		leaf, _ = x509.ParseCertificate(cert.Certificate[0])
+	}
+
+	fingerprint := sha256.Sum256(leaf.Raw)
+	pin := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+
+	hex := make([]string, len(fingerprint))
+	for i, b := range fingerprint {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+
+	info := &Info{
+		Subject: leaf.Subject.CommonName,
+		DNSNames: leaf.DNSNames,
+		IPAddresses: []string{},
+		NotBefore: leaf.NotBefore,
+		NotAfter: leaf.NotAfter,
+		Fingerprint: strings.Join(hex, ":"),
+		PublicKeyPin: base64.StdEncoding.EncodeToString(pin[:])}
+	for _, ip := range leaf.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+
+	return info
+}
+
+func (me *Provider) watch() {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-lifecycle.Done():
+			return
+		case <-ticker.C:
+			if err := me.check(); err != nil {
+				log.Printf("TLS certificate renewal failed: %v\n", err)
+			}
+		}
+	}
+}
+
+// check issues a new certificate if there is none, it expires soon or
+// misses a name or address.
+func (me *Provider) check() error {
+	dnsNames, ips := me.subjects()
+
+	me.RLock()
+	cert := me.cert
+	me.RUnlock()
+
+	reason := ""
+	if cert == nil {
+		reason = "no certificate"
+	} else if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		reason = err.Error()
+	} else if time.Now().Add(me.config.RenewBefore).After(leaf.NotAfter) {
+		reason = fmt.Sprintf("expiring at %s", leaf.NotAfter.Format(time.RFC3339))
+	} else if !covers(leaf, dnsNames, ips) {
+		reason = "names or addresses changed"
+	} else if pub, ok := leaf.PublicKey.(*ecdsa.PublicKey); !ok || !pub.Equal(&me.key.PublicKey) {
+		reason = "not of the device key"
+	}
+	if reason == "" {
+		return nil
+	}
+
+	log.Printf("issuing TLS certificate: %s\n", reason)
+
+	cert, err := me.issue(dnsNames, ips)
+	if err != nil {
+		return err
+	}
+
+	me.Lock()
+	me.cert = cert
+	me.Unlock()
+
+	log.Printf("TLS certificate SHA-256 fingerprint %s\n", me.Info().Fingerprint)
+
+	return nil
+}
+
+// subjects returns the names and addresses the certificate has to cover.
+func (me *Provider) subjects() ([]string, []net.IP) {
+	dnsNames := []string{"localhost"}
+	ips := []net.IP{}
+
+	if host, err := os.Hostname(); err == nil && host != "" && host != "localhost" {
+		dnsNames = append(dnsNames, host)
+		if !strings.Contains(host, ".") {
+			dnsNames = append(dnsNames, host + ".local")
+		}
+	}
+
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLinkLocalUnicast() {
+				ips = append(ips, ipnet.IP)
+			}
+		}
+	}
+
+	for _, host := range me.config.Hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			ips = append(ips, ip)
+		} else if host != "" {
+			dnsNames = append(dnsNames, host)
+		}
+	}
+
+	sort.Strings(dnsNames)
+
+	return dnsNames, ips
+}
+
+func covers(leaf *x509.Certificate, dnsNames []string, ips []net.IP) bool {
+	for _, name := range dnsNames {
+		found := false
+		for _, n := range leaf.DNSNames {
+			found = found || strings.EqualFold(n, name)
+		}
+		if !found {
+			return false
+		}
+	}
+
+	for _, ip := range ips {
+		found := false
+		for _, i := range leaf.IPAddresses {
+			found = found || i.Equal(ip)
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (me *Provider) issue(dnsNames []string, ips []net.IP) (*tls.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	cn := "localhost"
+	if host, err := os.Hostname(); err == nil && host != "" {
+		cn = host
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{CommonName: cn, Organization: []string{"confs"}},
+		NotBefore: now.Add(-time.Hour),
+		NotAfter: now.Add(me.config.Validity),
+		KeyUsage: x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames: dnsNames,
+		IPAddresses: ips}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &me.key.PublicKey, me.key)
+	if err != nil {
+		return nil, err
+	}
+
+	content := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := writeFile(me.path(CertFile), content, 0644); err != nil {
+		return nil, err
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: me.key, Leaf: leaf}, nil
+}
+
+// loadKey reads the key of the device, creating it the first time.
+func loadKey(path string) (*ecdsa.PrivateKey, error) {
+	content, err := ioutil.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(content)
+		if block == nil {
+			return nil, fmt.Errorf("no PEM data in '%s'", path)
+		}
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key in '%s': %v", path, err)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	log.Printf("creating TLS key '%s'\n", path)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	content = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if err := writeFile(path, content, 0600); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// writeFile replaces the file at once, so readers never see a part of it.
+func writeFile(path string, content []byte, mode os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "." + filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,288 @@
+package watch
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"errors"
+	"unsafe"
+	"strings"
+	"io/ioutil"
+	"crypto/md5"
+	"path/filepath"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	HashAttr string = "user.hash"
+	OriginAttr string = "user.origin"
+
+	dirMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO |
+		unix.IN_CLOSE_WRITE | unix.IN_ATTRIB | unix.IN_DELETE_SELF | unix.IN_ONLYDIR
+)
+
+type Op int
+
+const (
+	Write Op = iota
+	Remove
+)
+
+func (me Op) String() string {
+	if me == Remove {
+		return "remove"
+	}
+	return "write"
+}
+
+type Event struct {
+	Op Op
+	Path string
+	Origin string
+	Hash []byte
+}
+
+func (me Event) String() string {
+	return fmt.Sprintf("%s %s (origin: '%s', hash: %x)", me.Op, me.Path, me.Origin, me.Hash)
+}
+
+// Watcher reports the changes of the regular files of a drop zone subtree.
+// Directories created later on are watched as well. Files are reported
+// when they are closed after writing, moved in place or their extended
+// attributes change, so the origin and the hash are normally available.
+type Watcher struct {
+	sync.Mutex
+	root string
+	skip []string
+	fd int
+	file *os.File
+	dirs map[int]string
+	wds map[string]int
+	Events <-chan Event
+	Errors <-chan error
+	events chan Event
+	errors chan error
+}
+
+func NewWatcher(root string, skip ...string) (*Watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize inotify: %v", err)
+	}
+
+	events := make(chan Event, 64)
+	errs := make(chan error, 1)
+
+	me := &Watcher{
+		root: strings.TrimRight(root, "/"),
+		skip: skip,
+		fd: fd,
+		file: os.NewFile(uintptr(fd), "inotify"),
+		dirs: map[int]string{},
+		wds: map[string]int{},
+		Events: events,
+		Errors: errs,
+		events: events,
+		errors: errs}
+
+	if _, err := me.addTree(me.root); err != nil {
+		me.file.Close()
+		return nil, err
+	}
+
+	go me.loop()
+
+	return me, nil
+}
+
+func (me *Watcher) Close() error {
+	return me.file.Close()
+}
+
+// Files lists the regular files under the watched root with their origin
+// and hash, as if they were written right now.
+func (me *Watcher) Files() []Event {
+	files := []Event{}
+
+	filepath.Walk(me.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() && me.skipped(path) {
+			return filepath.SkipDir
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, newEvent(Write, path))
+		}
+		return nil
+	})
+
+	return files
+}
+
+func (me *Watcher) skipped(path string) bool {
+	for _, s := range me.skip {
+		if path == s || strings.HasPrefix(path, s + "/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (me *Watcher) addTree(dir string) ([]string, error) {
+	files := []string{}
+
+	if me.skipped(dir) {
+		return files, nil
+	}
+
+	me.Lock()
+	wd, err := unix.InotifyAddWatch(me.fd, dir, dirMask)
+	if err == nil {
+		me.dirs[wd] = dir
+		me.wds[dir] = wd
+	}
+	me.Unlock()
+
+	if err != nil {
+		return files, fmt.Errorf("failed to watch '%s': %v", dir, err)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return files, nil
+	}
+
+	for _, e := range entries {
+		path := dir + "/" + e.Name()
+		if e.IsDir() {
+			sub, err := me.addTree(path)
+			if err != nil {
+				return files, err
+			}
+			files = append(files, sub...)
+		} else if e.Mode().IsRegular() {
+			files = append(files, path)
+		}
+	}
+
+	return files, nil
+}
+
+func (me *Watcher) removeWatch(wd int) {
+	me.Lock()
+	defer me.Unlock()
+
+	if dir, found := me.dirs[wd]; found {
+		delete(me.wds, dir)
+		delete(me.dirs, wd)
+	}
+}
+
+func (me *Watcher) loop() {
+	buf := make([]byte, 64 * (unix.SizeofInotifyEvent + unix.NAME_MAX + 1))
+
+	defer close(me.events)
+
+	for {
+		n, err := me.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				me.fail(err)
+			}
+			return
+		}
+
+		for offset := 0;  offset + unix.SizeofInotifyEvent <= n; {
+			raw := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + unix.SizeofInotifyEvent
+			nameEnd := nameStart + int(raw.Len)
+			offset = nameEnd
+
+			name := strings.TrimRight(string(buf[nameStart:nameEnd]), "\x00")
+
+			me.handle(int(raw.Wd), raw.Mask, name)
+		}
+	}
+}
+
+func (me *Watcher) fail(err error) {
+	select {
+	case me.errors <- err:
+	default:
+	}
+}
+
+func (me *Watcher) handle(wd int, mask uint32, name string) {
+	me.Lock()
+	dir, found := me.dirs[wd]
+	me.Unlock()
+
+	if mask & (unix.IN_IGNORED | unix.IN_DELETE_SELF) != 0 {
+		me.removeWatch(wd)
+		return
+	}
+	if !found || name == "" {
+		return
+	}
+
+	path := dir + "/" + name
+
+	if me.skipped(path) {
+		return
+	}
+
+	if mask & unix.IN_ISDIR != 0 {
+		if mask & (unix.IN_CREATE | unix.IN_MOVED_TO) != 0 {
+			files, err := me.addTree(path)
+			if err != nil {
+				me.fail(err)
+			}
+			for _, f := range files {
+				me.events <- newEvent(Write, f)
+			}
+		}
+		return
+	}
+
+	switch {
+	case mask & (unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_ATTRIB) != 0:
+		if info, err := os.Lstat(path); err == nil && info.Mode().IsRegular() {
+			me.events <- newEvent(Write, path)
+		}
+	case mask & (unix.IN_DELETE | unix.IN_MOVED_FROM) != 0:
+		me.events <- Event{Op: Remove, Path: path}
+	}
+}
+
+func newEvent(op Op, path string) Event {
+	origin, _ := GetOrigin(path)
+	hash, _ := GetHash(path)
+
+	return Event{Op: op, Path: path, Origin: origin, Hash: hash}
+}
+
+func GetOrigin(path string) (string, error) {
+	buf := make([]byte, 64)
+
+	size, err := unix.Getxattr(path, OriginAttr, buf)
+	if err != nil {
+		return "", err
+	}
+
+	return string(buf[:size]), nil
+}
+
+func GetHash(path string) ([]byte, error) {
+	hash := make([]byte, md5.Size)
+
+	size, err := unix.Getxattr(path, HashAttr, hash)
+	if err != nil {
+		return nil, err
+	}
+	if size != md5.Size {
+		return nil, fmt.Errorf("invalid hash on '%s'", path)
+	}
+
+	return hash, nil
+}
@@ -2,11 +2,11 @@ package main
 
 import (
 	"fmt"
-	"log"
 	"flag"
 	"os"
 	"time"
 	"ostro/tar"
+	"ostro/lifecycle"
 )
 
 func main() {
@@ -20,8 +20,11 @@ func main() {
 	flag.StringVar(&tarFile, "tar-file", "confs.tar", "tar file name")
 	flag.StringVar(&archivePrefix, "archive-prefix", ".", "prefix to strip from tar files")
 
+	lifecycle.AddFlags()
 	flag.Parse()
 
+	lifecycle.Start()
+
 	tar.Initialize()
 
 	tarFilePath := fmt.Sprintf("%s/%s", tarDir, tarFile)
@@ -29,11 +32,11 @@ func main() {
 	checkIfFileExists(tarFilePath)
 	
 	if cfg, success = tar.NewConfs(tarFilePath, archivePrefix); !success {
-		os.Exit(1)
+		lifecycle.Exit(1)
 	}
 
 	if success = cfg.ExtractFiles("*"); !success {
-		os.Exit(1)
+		lifecycle.Exit(1)
 	}
 }
 
@@ -44,10 +47,10 @@ func checkIfFileExists(path string) {
 	for i := 0;  i < 12;  i++ {
 		if info, err := os.Stat(path); err == nil {
 			if !info.Mode().IsRegular() {
-				log.Fatalf("'%s' is not a regular file\n", path);
+				lifecycle.Fatalf("'%s' is not a regular file\n", path);
 			}
 			if info.Size() > int64(65536) {
-				log.Fatalf("Size of '%s' exceeds the allowed 64kB\n", path)
+				lifecycle.Fatalf("Size of '%s' exceeds the allowed 64kB\n", path)
 			}
 			return
 		}
@@ -57,5 +60,5 @@ func checkIfFileExists(path string) {
 		time.Sleep(wait)
 	}
 
-	log.Fatalf("File '%s' not found\n", path)
+	lifecycle.Fatalf("File '%s' not found\n", path)
 }